@@ -5,6 +5,8 @@ go 1.25.0
 require (
 	github.com/Antonboom/errname v1.1.1
 	github.com/google/go-cmp v0.7.0
+	go.yaml.in/yaml/v3 v3.0.4
+	golang.org/x/mod v0.34.0
 	golang.org/x/tools v0.43.0
 	lesiw.io/checker v0.12.1-0.20260208011356-b1121c49fa1e
 	lesiw.io/clerk v0.2.0
@@ -19,7 +21,6 @@ require (
 )
 
 require (
-	golang.org/x/mod v0.34.0 // indirect
 	golang.org/x/sync v0.20.0 // indirect
 	golang.org/x/sys v0.42.0 // indirect
 	golang.org/x/telemetry v0.0.0-20260311193753-579e4da9a98c // indirect
@@ -2,14 +2,15 @@ package goapp
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"strings"
 
+	"golang.org/x/mod/semver"
+
 	"labs.lesiw.io/ops/golang"
 	"lesiw.io/command"
-	"lesiw.io/command/sub"
-	"lesiw.io/fs/path"
 )
 
 var Targets = []golang.Target{
@@ -27,6 +28,14 @@ var Name string
 var Versionfile = "version.txt"
 
 func (op Ops) Build(ctx context.Context) error {
+	return BuildTargets(ctx, op, Targets)
+}
+
+// BuildTargets cleans, lints and tests the app like [Ops.Build],
+// but only builds it for targets.
+func BuildTargets(
+	ctx context.Context, op Ops, targets []golang.Target,
+) error {
 	if Name == "" {
 		return fmt.Errorf("no app name given")
 	}
@@ -39,7 +48,7 @@ func (op Ops) Build(ctx context.Context) error {
 	if err := op.Test(ctx); err != nil {
 		return err
 	}
-	for _, t := range Targets {
+	for _, t := range targets {
 		ctx := command.WithEnv(ctx, map[string]string{
 			"CGO_ENABLED": "0",
 			"GOOS":        t.Goos,
@@ -78,60 +87,234 @@ func (Ops) Clean(ctx context.Context) error {
 	return golang.Local.MkdirAll(ctx, "out")
 }
 
+// Bump commits, tags and pushes the release after the one in Versionfile.
+//
+// The working tree must be clean and the current branch must not be
+// behind its upstream. The commit and tag are pushed atomically,
+// and both are undone locally if the push fails.
 func (op Ops) Bump(ctx context.Context) error {
+	up, err := preflight(ctx)
+	if err != nil {
+		return err
+	}
 	if err := op.Check(ctx); err != nil {
 		return err
 	}
-	_, err := golang.Local.Read(ctx, "which", "bump")
+	curVersion, err := currentVersion(ctx)
 	if err != nil {
-		err = golang.Build.Exec(ctx,
-			"go", "install", "lesiw.io/bump@latest")
-		if err != nil {
-			return err
-		}
+		return err
+	}
+	version, err := golang.BumpVersion(ctx, curVersion)
+	if err != nil {
+		return err
+	}
+	return release(ctx, up, version)
+}
+
+// Prerelease tags and pushes the next release candidate,
+// such as v1.4.0-rc.1, or v1.4.0-rc.2 if v1.4.0-rc.1 already exists.
+// Versionfile is left at the latest release. The tag is pushed
+// atomically with the current branch, with the same safeguards as Bump.
+func (op Ops) Prerelease(ctx context.Context) error {
+	up, err := preflight(ctx)
+	if err != nil {
+		return err
+	}
+	if err := op.Check(ctx); err != nil {
+		return err
 	}
-	curVersion, err := golang.Build.ReadFile(ctx, Versionfile)
+	curVersion, err := currentVersion(ctx)
 	if err != nil {
 		return err
 	}
-	which, err := golang.Local.Read(ctx, "which", "bump")
+	latest, err := golang.Local.Read(ctx,
+		"git", "describe", "--abbrev=0", "--tags")
 	if err != nil {
 		return err
 	}
-	m := sub.Machine(
-		golang.Local.Unshell(), path.Dir(which))
-	bumpsh := command.Shell(m, "bump")
+	version, err := golang.NextPrerelease(ctx, curVersion, latest)
+	if err != nil {
+		return err
+	}
+	return prerelease(ctx, up, version)
+}
 
-	var versionBuf strings.Builder
-	_, err = command.Copy(
-		&versionBuf,
-		strings.NewReader(string(curVersion)+"\n"),
-		command.NewStream(ctx, bumpsh, "bump", "-s", "1"),
-	)
+// prerelease tags HEAD as version and pushes the tag atomically with the
+// current branch, so the tag never points at unpushed commits.
+// If the push fails, the local tag is deleted.
+func prerelease(ctx context.Context, up upstream, version string) error {
+	err := golang.Mutate(ctx, golang.Local, "git", "tag", version)
 	if err != nil {
 		return err
 	}
-	version := strings.TrimSpace(versionBuf.String())
+	err = golang.Mutate(ctx, golang.Local,
+		"git", "push", "--atomic", up.remote,
+		"HEAD:"+up.merge, "refs/tags/"+version)
+	if err != nil {
+		if rerr := golang.Local.Exec(ctx,
+			"git", "tag", "-d", version); rerr != nil {
+			err = errors.Join(err, fmt.Errorf("rollback: %w", rerr))
+		}
+		return err
+	}
+	return nil
+}
 
-	err = golang.Local.WriteFile(ctx,
-		Versionfile, []byte(version+"\n"))
+// Release promotes the latest release candidate to its final version,
+// writing it to Versionfile, then commits, tags and pushes it
+// with the same safeguards as Bump. HEAD must be the candidate.
+func (op Ops) Release(ctx context.Context) error {
+	up, err := preflight(ctx)
 	if err != nil {
 		return err
 	}
-	err = golang.Local.Exec(ctx, "git", "add", Versionfile)
+	if err := op.Check(ctx); err != nil {
+		return err
+	}
+	curVersion, err := currentVersion(ctx)
 	if err != nil {
 		return err
 	}
-	err = golang.Local.Exec(ctx,
-		"git", "commit", "-m", version)
+	version, err := candidate(ctx, curVersion)
+	if err != nil {
+		return err
+	}
+	return release(ctx, up, version)
+}
+
+// candidate returns the final version of the latest release candidate,
+// which must be newer than cur and tag HEAD, so that the release is the
+// code that shipped as the candidate.
+func candidate(ctx context.Context, cur string) (string, error) {
+	latest, err := golang.Local.Read(ctx,
+		"git", "describe", "--abbrev=0", "--tags")
+	if err != nil {
+		return "", err
+	}
+	version, err := golang.FinalVersion(latest)
+	if err != nil {
+		return "", fmt.Errorf("no release candidate to promote: %w", err)
+	}
+	if semver.Compare(version, cur) <= 0 {
+		return "", fmt.Errorf("release candidate %s is not newer than %s",
+			latest, cur)
+	}
+	rc, err := golang.Local.Read(ctx,
+		"git", "rev-parse", latest+"^{commit}")
+	if err != nil {
+		return "", fmt.Errorf("find commit of %s: %w", latest, err)
+	}
+	head, err := golang.Local.Read(ctx, "git", "rev-parse", "HEAD")
+	if err != nil {
+		return "", err
+	}
+	if head != rc {
+		return "", fmt.Errorf("HEAD has moved past release candidate %s; "+
+			"cut a new candidate first", latest)
+	}
+	return version, nil
+}
+
+func currentVersion(ctx context.Context) (string, error) {
+	buf, err := golang.Build.ReadFile(ctx, Versionfile)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(buf)), nil
+}
+
+type upstream struct {
+	remote string // Remote name, such as origin.
+	merge  string // Upstream branch ref, such as refs/heads/main.
+}
+
+// preflight checks that the working tree is clean and that the current
+// branch is not behind its upstream, then returns that upstream.
+func preflight(ctx context.Context) (upstream, error) {
+	status, err := golang.Local.Read(ctx, "git", "status", "--porcelain")
+	if err != nil {
+		return upstream{}, fmt.Errorf("check working tree: %w", err)
+	}
+	if status != "" {
+		return upstream{}, fmt.Errorf(
+			"working tree is not clean:\n%s", status)
+	}
+	branch, err := golang.Local.Read(ctx,
+		"git", "branch", "--show-current")
+	if err != nil {
+		return upstream{}, fmt.Errorf("get current branch: %w", err)
+	}
+	if branch == "" {
+		return upstream{}, fmt.Errorf("not on a branch")
+	}
+	var up upstream
+	up.remote, err = golang.Local.Read(ctx,
+		"git", "config", "--get", "branch."+branch+".remote")
+	if err != nil {
+		return upstream{}, fmt.Errorf("no upstream for %s: %w", branch, err)
+	}
+	up.merge, err = golang.Local.Read(ctx,
+		"git", "config", "--get", "branch."+branch+".merge")
+	if err != nil {
+		return upstream{}, fmt.Errorf("no upstream for %s: %w", branch, err)
+	}
+	err = golang.Local.Exec(ctx, "git", "fetch", up.remote)
+	if err != nil {
+		return upstream{}, fmt.Errorf("fetch from %s: %w", up.remote, err)
+	}
+	behind, err := golang.Local.Read(ctx,
+		"git", "rev-list", "--count", "HEAD..@{upstream}")
+	if err != nil {
+		return upstream{}, fmt.Errorf("compare with upstream: %w", err)
+	}
+	if behind != "0" {
+		return upstream{}, fmt.Errorf(
+			"%s is %s commit(s) behind its upstream", branch, behind)
+	}
+	return up, nil
+}
+
+// release writes version to Versionfile, then commits, tags and pushes it.
+// If any step fails, the local commit and tag are undone.
+func release(ctx context.Context, up upstream, version string) (err error) {
+	var undo [][]string
+	defer func() {
+		if err == nil {
+			return
+		}
+		for i := len(undo) - 1; i >= 0; i-- {
+			if rerr := golang.Local.Exec(ctx, undo[i]...); rerr != nil {
+				err = errors.Join(err, fmt.Errorf("rollback: %w", rerr))
+			}
+		}
+	}()
+	if golang.IsDryRun(ctx) {
+		fmt.Println("dry run: write", version, "to", Versionfile)
+	} else {
+		err = golang.Local.WriteFile(ctx,
+			Versionfile, []byte(version+"\n"))
+		if err != nil {
+			return err
+		}
+		undo = [][]string{{"git", "checkout", "HEAD", "--", Versionfile}}
+	}
+	err = golang.Mutate(ctx, golang.Local, "git", "add", Versionfile)
 	if err != nil {
 		return err
 	}
-	if err := golang.Local.Exec(ctx, "git", "tag", version); err != nil {
+	err = golang.Mutate(ctx, golang.Local,
+		"git", "commit", "-m", version)
+	if err != nil {
 		return err
 	}
-	if err := golang.Local.Exec(ctx, "git", "push"); err != nil {
+	// The tree was clean before the commit, so nothing else is lost.
+	undo = [][]string{{"git", "reset", "--hard", "HEAD~1"}}
+	err = golang.Mutate(ctx, golang.Local, "git", "tag", version)
+	if err != nil {
 		return err
 	}
-	return golang.Local.Exec(ctx, "git", "push", "--tags")
+	undo = append(undo, []string{"git", "tag", "-d", version})
+	return golang.Mutate(ctx, golang.Local,
+		"git", "push", "--atomic", up.remote,
+		"HEAD:"+up.merge, "refs/tags/"+version)
 }
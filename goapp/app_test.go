@@ -0,0 +1,141 @@
+package goapp
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"labs.lesiw.io/ops/golang"
+	"lesiw.io/command"
+	"lesiw.io/command/mock"
+)
+
+func swap[T any](t *testing.T, ptr *T, val T) {
+	t.Helper()
+	old := *ptr
+	*ptr = val
+	t.Cleanup(func() { *ptr = old })
+}
+
+func setupMock(t *testing.T) *mock.Machine {
+	t.Helper()
+	m := new(mock.Machine)
+	m.SetOS("linux")
+	m.SetArch("amd64")
+	sh := command.Shell(m, "git")
+	swap(t, &golang.Build, sh)
+	swap(t, &golang.Local, sh)
+	return m
+}
+
+var buffer = strings.NewReader
+
+func TestPreflightDirty(t *testing.T) {
+	m := setupMock(t)
+	m.Return(buffer(" M version.txt\n"), "git", "status", "--porcelain")
+
+	_, err := preflight(context.Background())
+	if err == nil {
+		t.Fatal("preflight() should fail on a dirty tree")
+	}
+	if !strings.Contains(err.Error(), "not clean") {
+		t.Errorf("error = %v, want 'not clean'", err)
+	}
+}
+
+func TestPreflightBehind(t *testing.T) {
+	m := setupMock(t)
+	m.Return(buffer("main"), "git", "branch", "--show-current")
+	m.Return(buffer("origin"),
+		"git", "config", "--get", "branch.main.remote")
+	m.Return(buffer("refs/heads/main"),
+		"git", "config", "--get", "branch.main.merge")
+	m.Return(buffer("2"),
+		"git", "rev-list", "--count", "HEAD..@{upstream}")
+
+	_, err := preflight(context.Background())
+	if err == nil {
+		t.Fatal("preflight() should fail when behind upstream")
+	}
+	if !strings.Contains(err.Error(), "behind") {
+		t.Errorf("error = %v, want 'behind'", err)
+	}
+}
+
+func TestReleaseRollback(t *testing.T) {
+	m := setupMock(t)
+	m.Return(command.Fail(&command.Error{Code: 1}),
+		"git", "push", "--atomic", "origin",
+		"HEAD:refs/heads/main", "refs/tags/v1.2.0")
+
+	up := upstream{remote: "origin", merge: "refs/heads/main"}
+	err := release(context.Background(), up, "v1.2.0")
+	if err == nil {
+		t.Fatal("release() should fail when the push fails")
+	}
+
+	got := mock.Calls(m, "git")
+	want := []mock.Call{
+		{Args: []string{"git", "add", "version.txt"}},
+		{Args: []string{"git", "commit", "-m", "v1.2.0"}},
+		{Args: []string{"git", "tag", "v1.2.0"}},
+		{Args: []string{
+			"git", "push", "--atomic", "origin",
+			"HEAD:refs/heads/main", "refs/tags/v1.2.0",
+		}},
+		{Args: []string{"git", "tag", "-d", "v1.2.0"}},
+		{Args: []string{"git", "reset", "--hard", "HEAD~1"}},
+	}
+	if !cmp.Equal(want, got) {
+		t.Errorf("git calls: -want +got\n%s", cmp.Diff(want, got))
+	}
+}
+
+func TestCandidateHeadAhead(t *testing.T) {
+	m := setupMock(t)
+	m.Return(buffer("v1.4.0-rc.2"), "git", "describe")
+	m.Return(buffer("0a1b2c3"), "git", "rev-parse", "v1.4.0-rc.2^{commit}")
+	m.Return(buffer("4d5e6f7"), "git", "rev-parse", "HEAD")
+
+	_, err := candidate(context.Background(), "v1.3.0")
+	if err == nil {
+		t.Fatal("candidate() should fail when HEAD is past the candidate")
+	}
+	if !strings.Contains(err.Error(), "moved past") {
+		t.Errorf("error = %v, want 'moved past'", err)
+	}
+
+	m.Return(buffer("0a1b2c3"), "git", "rev-parse", "HEAD")
+	version, err := candidate(context.Background(), "v1.3.0")
+	if err != nil || version != "v1.4.0" {
+		t.Errorf("candidate() = %q, %v, want v1.4.0", version, err)
+	}
+}
+
+func TestPrereleasePushesBranch(t *testing.T) {
+	m := setupMock(t)
+	m.Return(command.Fail(&command.Error{Code: 1}),
+		"git", "push", "--atomic", "origin",
+		"HEAD:refs/heads/main", "refs/tags/v1.4.0-rc.1")
+
+	up := upstream{remote: "origin", merge: "refs/heads/main"}
+	err := prerelease(context.Background(), up, "v1.4.0-rc.1")
+	if err == nil {
+		t.Fatal("prerelease() should fail when the push fails")
+	}
+
+	got := mock.Calls(m, "git")
+	want := []mock.Call{
+		{Args: []string{"git", "tag", "v1.4.0-rc.1"}},
+		{Args: []string{
+			"git", "push", "--atomic", "origin",
+			"HEAD:refs/heads/main", "refs/tags/v1.4.0-rc.1",
+		}},
+		{Args: []string{"git", "tag", "-d", "v1.4.0-rc.1"}},
+	}
+	if !cmp.Equal(want, got) {
+		t.Errorf("git calls: -want +got\n%s", cmp.Diff(want, got))
+	}
+}
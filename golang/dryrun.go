@@ -0,0 +1,61 @@
+package golang
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+
+	"lesiw.io/command"
+)
+
+// DryRun disables commands that publish or otherwise mutate state
+// outside the working tree. Read-only steps still run, and each
+// skipped command is printed instead.
+//
+// Dry-run mode is also enabled by setting DRYRUN in the environment.
+var DryRun bool
+
+// IsDryRun reports whether dry-run mode is enabled.
+func IsDryRun(ctx context.Context) bool {
+	return DryRun || Local.Env(ctx, "DRYRUN") != ""
+}
+
+// Mutate runs a command that mutates state outside the working tree.
+// In dry-run mode, the command is printed instead of run.
+func Mutate(ctx context.Context, m command.Machine, args ...string) error {
+	if IsDryRun(ctx) {
+		fmt.Println("dry run:", Quote(args...))
+		return nil
+	}
+	return command.Exec(ctx, m, args...)
+}
+
+// MutateFrom is like [Mutate], but copies r to the command's input.
+// In dry-run mode, r is not read, so it is safe to pass secrets.
+func MutateFrom(
+	ctx context.Context, m command.Machine, r io.Reader, args ...string,
+) error {
+	if IsDryRun(ctx) {
+		fmt.Println("dry run:", Quote(args...), "< -")
+		return nil
+	}
+	_, err := command.Copy(command.NewWriter(ctx, m, args...), r)
+	return err
+}
+
+// Quote formats args as a POSIX shell command line.
+func Quote(args ...string) string {
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		if arg != "" && strings.Trim(arg,
+			"abcdefghijklmnopqrstuvwxyz"+
+				"ABCDEFGHIJKLMNOPQRSTUVWXYZ"+
+				"0123456789-_./:=@%+,") == "" {
+			quoted[i] = arg
+			continue
+		}
+		quoted[i] = "'" + strings.ReplaceAll(arg, "'", `'\''`) + "'"
+	}
+	return strings.Join(quoted, " ")
+}
@@ -265,6 +265,7 @@ func (Ops) Cov(ctx context.Context) error {
 
 // Promote fast-forwards main to next after CI passes.
 // Requires being on the next branch with all changes pushed.
+// In dry-run mode, the checks run but main is left untouched.
 func (Ops) Promote(ctx context.Context) error {
 	branch, err := Local.Read(ctx,
 		"git", "branch", "--show-current")
@@ -336,17 +337,20 @@ func (Ops) Promote(ctx context.Context) error {
 	}
 
 	fmt.Println("Promoting next to main...")
-	err = Local.Exec(ctx,
+	err = Mutate(ctx, Local,
 		"git", "push", remote, "next:main")
 	if err != nil {
 		return fmt.Errorf("push next:main: %w", err)
 	}
 
-	err = Local.Exec(ctx,
+	err = Mutate(ctx, Local,
 		"git", "fetch", remote, "main:main")
 	if err != nil {
 		return fmt.Errorf("update local main: %w", err)
 	}
+	if IsDryRun(ctx) {
+		return nil
+	}
 
 	fmt.Println("Successfully promoted next to main")
 	return nil
@@ -523,6 +527,11 @@ func inTempDir(
 	return fn(ctx)
 }
 
+// Modules returns the directories of all Go modules in the tree.
+func Modules(ctx context.Context) ([]string, error) {
+	return modules(ctx)
+}
+
 func modules(ctx context.Context) ([]string, error) {
 	var mods []string
 	if err := findModules(ctx, Build, ".", &mods); err != nil {
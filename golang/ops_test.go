@@ -168,6 +168,43 @@ func TestPromoteSuccess(t *testing.T) {
 	}
 }
 
+func TestPromoteDryRun(t *testing.T) {
+	m := setupMock(t, "git", "gh")
+	swap(t, &DryRun, true)
+	m.Return(buffer("next"),
+		"git", "branch", "--show-current")
+	m.Return(buffer("origin"),
+		"git", "config", "--get", "branch.next.remote")
+	m.Return(buffer("abc123"),
+		"git", "rev-parse", "origin/main")
+	m.Return(buffer("def456"),
+		"git", "rev-parse", "next")
+	m.Return(buffer("def456"), "gh", "run", "list",
+		"--branch", "next", "--limit", "1",
+		"--json", "headSha", "--jq", ".[0].headSha")
+	m.Return(buffer("success"), "gh", "run", "list",
+		"--branch", "next", "--limit", "1",
+		"--json", "conclusion",
+		"--jq", ".[0].conclusion")
+
+	err := Ops{}.Promote(context.Background())
+	if err != nil {
+		t.Fatalf("Promote() failed: %v", err)
+	}
+
+	if got := mock.Calls(m, "git", "push"); len(got) > 0 {
+		t.Errorf("dry run pushed: %v", got)
+	}
+	got := mock.Calls(m, "git", "fetch")
+	want := []mock.Call{
+		{Args: []string{"git", "fetch", "origin"}},
+	}
+	if !cmp.Equal(want, got) {
+		t.Errorf("git fetch calls: -want +got\n%s",
+			cmp.Diff(want, got))
+	}
+}
+
 func TestPromoteCIFailed(t *testing.T) {
 	m := setupMock(t, "git", "gh")
 	m.Return(buffer("next"),
@@ -271,6 +308,14 @@ func TestPromoteAlreadyEqual(t *testing.T) {
 	}
 }
 
+func TestQuote(t *testing.T) {
+	got := Quote("git", "commit", "-m", "it's v1.0.0", "")
+	want := `git commit -m 'it'\''s v1.0.0' ''`
+	if got != want {
+		t.Errorf("Quote() = %s, want %s", got, want)
+	}
+}
+
 func TestAnalyzers(t *testing.T) {
 	got := Analyzers()
 	if len(got) == 0 {
@@ -0,0 +1,99 @@
+package golang
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"golang.org/x/mod/semver"
+
+	"lesiw.io/command"
+	"lesiw.io/command/sub"
+	"lesiw.io/fs/path"
+)
+
+// PrereleaseID names the pre-release series cut by the Prerelease ops,
+// as in v1.4.0-rc.1.
+var PrereleaseID = "rc"
+
+// BumpVersion returns the release after cur,
+// incrementing the minor version with lesiw.io/bump.
+func BumpVersion(ctx context.Context, cur string) (string, error) {
+	_, err := Local.Read(ctx, "which", "bump")
+	if err != nil {
+		err = Build.Exec(ctx, "go", "install", "lesiw.io/bump@latest")
+		if err != nil {
+			return "", err
+		}
+	}
+	which, err := Local.Read(ctx, "which", "bump")
+	if err != nil {
+		return "", err
+	}
+	m := sub.Machine(Local.Unshell(), path.Dir(which))
+	bumpsh := command.Shell(m, "bump")
+
+	var versionBuf strings.Builder
+	_, err = command.Copy(
+		&versionBuf,
+		strings.NewReader(cur+"\n"),
+		command.NewStream(ctx, bumpsh, "bump", "-s", "1"),
+	)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(versionBuf.String()), nil
+}
+
+// NextPrerelease returns the pre-release that follows release.
+//
+// If latest is a pre-release in the PrereleaseID series for a version
+// newer than release, its number is incremented, so v1.4.0-rc.1 is
+// followed by v1.4.0-rc.2. Otherwise, a new series is started for the
+// release after release, as in v1.4.0-rc.1.
+func NextPrerelease(
+	ctx context.Context, release, latest string,
+) (string, error) {
+	if final, n, ok := parsePrerelease(latest); ok &&
+		semver.Compare(final, release) > 0 {
+		return fmt.Sprintf("%s-%s.%d", final, PrereleaseID, n+1), nil
+	}
+	next, err := BumpVersion(ctx, release)
+	if err != nil {
+		return "", err
+	}
+	return next + "-" + PrereleaseID + ".1", nil
+}
+
+// FinalVersion returns the release that the pre-release v leads up to.
+// It returns an error if v is not a pre-release.
+func FinalVersion(v string) (string, error) {
+	pre := semver.Prerelease(v)
+	if !semver.IsValid(v) || pre == "" {
+		return "", fmt.Errorf("%q is not a pre-release version", v)
+	}
+	return strings.TrimSuffix(v, pre+semver.Build(v)), nil
+}
+
+// IsPrerelease reports whether v is a pre-release version.
+func IsPrerelease(v string) bool {
+	return semver.IsValid(v) && semver.Prerelease(v) != ""
+}
+
+func parsePrerelease(v string) (final string, n int, ok bool) {
+	final, err := FinalVersion(v)
+	if err != nil {
+		return "", 0, false
+	}
+	num, ok := strings.CutPrefix(
+		semver.Prerelease(v), "-"+PrereleaseID+".")
+	if !ok {
+		return "", 0, false
+	}
+	n, err = strconv.Atoi(num)
+	if err != nil || n < 1 {
+		return "", 0, false
+	}
+	return final, n, true
+}
@@ -0,0 +1,53 @@
+package golang
+
+import (
+	"context"
+	"testing"
+)
+
+func TestNextPrerelease(t *testing.T) {
+	tests := []struct {
+		release, latest, want string
+	}{
+		{"v1.3.0", "v1.4.0-rc.1", "v1.4.0-rc.2"},
+		{"v1.3.0", "v1.4.0-rc.9", "v1.4.0-rc.10"},
+		{"v0.9.2", "v1.0.0-rc.3", "v1.0.0-rc.4"},
+	}
+	for _, tt := range tests {
+		got, err := NextPrerelease(
+			context.Background(), tt.release, tt.latest)
+		if err != nil {
+			t.Errorf("NextPrerelease(%q, %q) err: %v",
+				tt.release, tt.latest, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("NextPrerelease(%q, %q) = %q, want %q",
+				tt.release, tt.latest, got, tt.want)
+		}
+	}
+}
+
+func TestFinalVersion(t *testing.T) {
+	tests := []struct {
+		v, want string
+		ok      bool
+	}{
+		{"v1.4.0-rc.1", "v1.4.0", true},
+		{"v1.4.0-rc.2+meta", "v1.4.0", true},
+		{"v1.4.0", "", false},
+		{"main", "", false},
+	}
+	for _, tt := range tests {
+		got, err := FinalVersion(tt.v)
+		if (err == nil) != tt.ok {
+			t.Errorf("FinalVersion(%q) err = %v, want ok = %v",
+				tt.v, err, tt.ok)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("FinalVersion(%q) = %q, want %q",
+				tt.v, got, tt.want)
+		}
+	}
+}
@@ -4,10 +4,12 @@ import (
 	"context"
 	"fmt"
 	"strings"
+	"time"
+
+	"golang.org/x/mod/modfile"
+	"golang.org/x/mod/semver"
 
 	"labs.lesiw.io/ops/golang"
-	"lesiw.io/command"
-	"lesiw.io/command/sub"
 	"lesiw.io/fs/path"
 )
 
@@ -17,62 +19,270 @@ func (op Ops) Build(ctx context.Context) error {
 	return op.Check(ctx)
 }
 
+// Bump tags and pushes the release after the latest release
+// of the root module and of each nested module that changed since its
+// latest release. Nested module tags are prefixed by their directory,
+// as in sub/mod/v1.2.3.
+// Pre-release tags are ignored when finding the latest release.
 func (op Ops) Bump(ctx context.Context) error {
 	if err := op.Check(ctx); err != nil {
 		return err
 	}
-	_, err := golang.Local.Read(ctx, "which", "bump")
+	mods, err := loadModules(ctx)
 	if err != nil {
-		err = golang.Build.Exec(ctx,
-			"go", "install", "lesiw.io/bump@latest")
-		if err != nil {
-			return err
-		}
+		return err
+	}
+	return bumpModules(ctx, mods)
+}
+
+// Prerelease tags and pushes the next release candidate,
+// such as v1.4.0-rc.1, or v1.4.0-rc.2 if v1.4.0-rc.1 already exists.
+func (op Ops) Prerelease(ctx context.Context) error {
+	if err := op.Check(ctx); err != nil {
+		return err
+	}
+	release, err := latestTag(ctx, "", false)
+	if err != nil {
+		return err
+	}
+	latest, err := latestTag(ctx, "", true)
+	if err != nil {
+		return err
 	}
-	which, err := golang.Local.Read(ctx, "which", "bump")
+	version, err := golang.NextPrerelease(ctx, release, latest)
 	if err != nil {
 		return err
 	}
-	m := sub.Machine(golang.Local.Unshell(), path.Dir(which))
-	bumpsh := command.Shell(m, "bump")
+	return tag(ctx, version)
+}
+
+// Release promotes the latest release candidate to its final version,
+// so v1.4.0-rc.2 is tagged as v1.4.0. The final version is tagged on
+// the candidate's commit, even if HEAD has moved past it.
+func (op Ops) Release(ctx context.Context) error {
+	if err := op.Check(ctx); err != nil {
+		return err
+	}
+	return promote(ctx)
+}
 
-	var versionBuf strings.Builder
-	_, err = command.Copy(
-		&versionBuf,
-		command.NewReader(ctx, golang.Local,
-			"git", "describe", "--abbrev=0", "--tags"),
-		command.NewStream(ctx, bumpsh, "bump", "-s", "1"),
-	)
+func promote(ctx context.Context) error {
+	latest, err := latestTag(ctx, "", true)
 	if err != nil {
 		return err
 	}
-	version := strings.TrimSpace(versionBuf.String())
+	version, err := golang.FinalVersion(latest)
+	if err != nil {
+		return fmt.Errorf("no release candidate to promote: %w", err)
+	}
+	commit, err := golang.Local.Read(ctx,
+		"git", "rev-parse", latest+"^{commit}")
+	if err != nil {
+		return fmt.Errorf("find commit of %s: %w", latest, err)
+	}
+	err = golang.Mutate(ctx, golang.Local, "git", "tag", version, commit)
+	if err != nil {
+		return err
+	}
+	return golang.Mutate(ctx, golang.Local, "git", "push", "--tags")
+}
 
-	if err := golang.Local.Exec(ctx, "git", "tag", version); err != nil {
+// latestTag returns the latest tag reachable from HEAD with the given
+// module prefix. Pre-release tags are skipped unless pre is set.
+func latestTag(
+	ctx context.Context, prefix string, pre bool,
+) (string, error) {
+	args := []string{"git", "describe", "--abbrev=0", "--tags",
+		"--match", prefix + "v[0-9]*"}
+	if !pre {
+		args = append(args, "--exclude", prefix+"v*-*")
+	}
+	return golang.Local.Read(ctx, args...)
+}
+
+func tag(ctx context.Context, version string) error {
+	err := golang.Mutate(ctx, golang.Local, "git", "tag", version)
+	if err != nil {
 		return err
 	}
-	if err := golang.Local.Exec(ctx, "git", "push"); err != nil {
+	if err := golang.Mutate(ctx, golang.Local, "git", "push"); err != nil {
 		return err
 	}
-	return golang.Local.Exec(ctx, "git", "push", "--tags")
+	return golang.Mutate(ctx, golang.Local, "git", "push", "--tags")
 }
 
+// ProxyTimeout bounds how long ProxyPing waits for the module proxy
+// to report each module version.
+var ProxyTimeout = 5 * time.Minute
+
+var proxyPollInterval = 10 * time.Second
+
+// ProxyPing asks the module proxy for every module in the repository
+// at its release tag for HEAD, or at HEAD if it has none,
+// and waits until the proxy reports that version.
 func (Ops) ProxyPing(ctx context.Context) error {
-	var ref string
-	tag, err := golang.Local.Read(ctx,
-		"git", "describe", "--exact-match", "--tags")
-	if err == nil {
-		ref = tag
-	} else {
-		ref, err = golang.Local.Read(ctx, "git", "rev-parse", "HEAD")
+	dirs, err := golang.Modules(ctx)
+	if err != nil {
+		return fmt.Errorf("find modules: %w", err)
+	}
+	for _, dir := range dirs {
+		if hidden(dir) {
+			continue
+		}
+		dir = moduleDir(dir)
+		mod, err := golang.Build.Read(ctx, "go", "-C", dir, "list", "-m")
 		if err != nil {
 			return err
 		}
+		prefix := tagPrefix(dir)
+		ref, err := golang.Local.Read(ctx,
+			"git", "describe", "--exact-match", "--tags",
+			"--match", prefix+"v[0-9]*")
+		if err == nil {
+			ref = strings.TrimPrefix(ref, prefix)
+		} else {
+			ref, err = golang.Local.Read(ctx, "git", "rev-parse", "HEAD")
+			if err != nil {
+				return err
+			}
+		}
+		if err := ping(ctx, mod, ref); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ping asks the module proxy for mod at ref, which makes the proxy fetch
+// and cache it, retrying until the proxy reports it or ProxyTimeout
+// passes.
+func ping(ctx context.Context, mod, ref string) error {
+	ctx, cancel := context.WithTimeout(ctx, ProxyTimeout)
+	defer cancel()
+	for {
+		out, err := golang.Build.Read(ctx, "go", "list", "-m",
+			fmt.Sprintf("%s@%s", mod, ref))
+		if err == nil {
+			fmt.Println(out)
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("proxy did not report %s@%s: %w",
+				mod, ref, err)
+		case <-time.After(proxyPollInterval):
+		}
+	}
+}
+
+// Retract retracts a published version of a module and tags a release
+// that publishes the retraction.
+//
+// The version is read from RETRACT, either as a tag such as v1.2.3 or
+// sub/mod/v1.2.3, or as a range such as [v1.2.0, v1.2.3].
+// The reason for the retraction is read from RATIONALE.
+func (op Ops) Retract(ctx context.Context) error {
+	spec := golang.Local.Env(ctx, "RETRACT")
+	rationale := golang.Local.Env(ctx, "RATIONALE")
+	if spec == "" || rationale == "" {
+		return fmt.Errorf("RETRACT and RATIONALE must be set")
+	}
+	dir, vi, err := parseRetract(spec)
+	if err != nil {
+		return err
+	}
+	if err := op.Check(ctx); err != nil {
+		return err
+	}
+	gomodPath := path.Join(dir, "go.mod")
+	gomod, err := golang.Build.ReadFile(ctx, gomodPath)
+	if err != nil {
+		return err
+	}
+	gomod, err = addRetract(gomod, vi, rationale)
+	if err != nil {
+		return fmt.Errorf("retract %s: %w", spec, err)
+	}
+	if golang.IsDryRun(ctx) {
+		fmt.Println("dry run: retract", spec, "in", gomodPath)
+	} else {
+		if err := golang.Build.WriteFile(ctx, gomodPath, gomod); err != nil {
+			return err
+		}
+	}
+	err = golang.Mutate(ctx, golang.Local, "git", "add", gomodPath)
+	if err != nil {
+		return err
 	}
-	mod, err := golang.Build.Read(ctx, "go", "list", "-m")
+	err = golang.Mutate(ctx, golang.Local,
+		"git", "commit", "-m", "Retract "+spec)
 	if err != nil {
 		return err
 	}
-	return golang.Build.Exec(ctx, "go", "list", "-m",
-		fmt.Sprintf("%s@%s", mod, ref))
+	prefix := tagPrefix(dir)
+	release, err := latestTag(ctx, prefix, false)
+	if err != nil {
+		return err
+	}
+	version, err := bumpVersion(ctx, strings.TrimPrefix(release, prefix))
+	if err != nil {
+		return err
+	}
+	if err := tag(ctx, prefix+version); err != nil {
+		return err
+	}
+	if golang.IsDryRun(ctx) {
+		return nil
+	}
+	mod, err := golang.Build.Read(ctx, "go", "-C", dir, "list", "-m")
+	if err != nil {
+		return err
+	}
+	return ping(ctx, mod, version)
+}
+
+// parseRetract parses a RETRACT value into a module directory
+// and the version interval to retract.
+func parseRetract(
+	spec string,
+) (dir string, vi modfile.VersionInterval, err error) {
+	dir = "."
+	low, high := spec, spec
+	if inner, ok := strings.CutPrefix(spec, "["); ok {
+		inner, ok = strings.CutSuffix(inner, "]")
+		l, h, comma := strings.Cut(inner, ",")
+		if !ok || !comma {
+			return "", vi, fmt.Errorf("bad version range: %s", spec)
+		}
+		low, high = strings.TrimSpace(l), strings.TrimSpace(h)
+	}
+	if i := strings.LastIndex(low, "/"); i >= 0 {
+		dir = moduleDir(low[:i])
+		low = low[i+1:]
+	}
+	if i := strings.LastIndex(high, "/"); i >= 0 {
+		if moduleDir(high[:i]) != dir {
+			return "", vi, fmt.Errorf("bad version range: %s", spec)
+		}
+		high = high[i+1:]
+	}
+	if !semver.IsValid(low) || !semver.IsValid(high) ||
+		semver.Compare(low, high) > 0 {
+		return "", vi, fmt.Errorf("bad version: %s", spec)
+	}
+	return dir, modfile.VersionInterval{Low: low, High: high}, nil
+}
+
+// addRetract adds a retract directive for vi to the go.mod file gomod.
+func addRetract(
+	gomod []byte, vi modfile.VersionInterval, rationale string,
+) ([]byte, error) {
+	f, err := modfile.Parse("go.mod", gomod, nil)
+	if err != nil {
+		return nil, err
+	}
+	if err := f.AddRetract(vi, rationale); err != nil {
+		return nil, err
+	}
+	return f.Format()
 }
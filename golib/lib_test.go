@@ -2,7 +2,13 @@ package golib
 
 import (
 	"context"
+	"slices"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"golang.org/x/mod/modfile"
 
 	"labs.lesiw.io/ops/golang"
 	"lesiw.io/command"
@@ -55,3 +61,260 @@ func TestCheckInherited(t *testing.T) {
 			buildCount, len(golang.CheckTargets))
 	}
 }
+
+func TestSortModules(t *testing.T) {
+	mods := []*module{
+		{dir: ".", path: "example.com/root",
+			deps: []string{"example.com/root/b"}},
+		{dir: "b", path: "example.com/root/b",
+			deps: []string{"example.com/root/a"}},
+		{dir: "a", path: "example.com/root/a"},
+		{dir: "c", path: "example.com/root/c"},
+	}
+
+	sorted, err := sortModules(mods)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	for _, m := range sorted {
+		got = append(got, m.dir)
+	}
+	want := []string{"a", "b", ".", "c"}
+	if !cmp.Equal(want, got) {
+		t.Errorf("sortModules(): -want +got\n%s", cmp.Diff(want, got))
+	}
+}
+
+func TestSortModulesCycle(t *testing.T) {
+	mods := []*module{
+		{dir: "a", path: "example.com/a",
+			deps: []string{"example.com/b"}},
+		{dir: "b", path: "example.com/b",
+			deps: []string{"example.com/a"}},
+	}
+
+	if _, err := sortModules(mods); err == nil {
+		t.Fatal("sortModules() should fail on a dependency cycle")
+	}
+}
+
+func TestBumpModules(t *testing.T) {
+	ctx := context.Background()
+	m := new(mock.Machine)
+	m.SetOS("linux")
+	m.SetArch("amd64")
+	sh := command.Shell(m, "go", "git")
+	swap(t, &golang.Build, sh)
+	swap(t, &golang.Local, sh)
+	swap(t, &bumpVersion,
+		func(_ context.Context, cur string) (string, error) {
+			return map[string]string{
+				"v1.0.0": "v1.1.0",
+				"v2.3.0": "v2.4.0",
+			}[cur], nil
+		})
+	for _, name := range []string{"go.mod", "a/go.mod", "b/go.mod"} {
+		if err := sh.WriteFile(ctx, name, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	m.Return(command.Fail(&command.Error{Code: 1}),
+		"git", "check-ignore")
+	gomods := map[string]string{
+		".": `{"Module": {"Path": "example.com/root"},
+			"Require": [{"Path": "example.com/root/a"}]}`,
+		"a": `{"Module": {"Path": "example.com/root/a"}}`,
+		"b": `{"Module": {"Path": "example.com/root/b"}}`,
+	}
+	for dir, gomod := range gomods {
+		m.Return(strings.NewReader(gomod),
+			"go", "-C", dir, "mod", "edit", "-json")
+	}
+	for prefix, tag := range map[string]string{
+		"": "v2.3.0", "a/": "a/v1.0.0", "b/": "b/v0.2.0",
+	} {
+		m.Return(strings.NewReader(tag+"\n"), "git", "describe",
+			"--abbrev=0", "--tags", "--match", prefix+"v[0-9]*")
+	}
+	m.Return(strings.NewReader("a/a.go\n"),
+		"git", "diff", "--name-only", "a/v1.0.0", "HEAD", "--", "a")
+
+	mods, err := loadModules(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded := len(mock.Calls(m))
+	if err := bumpModules(ctx, mods); err != nil {
+		t.Fatal(err)
+	}
+
+	got := mock.Calls(m)[loaded:]
+	want := []mock.Call{
+		{Args: []string{"git", "tag", "a/v1.1.0"}},
+		{Args: []string{"git", "push"}},
+		{Args: []string{"git", "push", "--tags"}},
+		{Args: []string{"go", "list", "-m",
+			"example.com/root/a@v1.1.0"}},
+		{Args: []string{"go", "-C", ".", "get",
+			"example.com/root/a@v1.1.0"}},
+		{Args: []string{"git", "add", "./go.mod", "./go.sum"}},
+		{Args: []string{"git", "commit", "-m",
+			"example.com/root: require example.com/root/a@v1.1.0"}},
+		{Args: []string{"git", "tag", "v2.4.0"}},
+		{Args: []string{"git", "push"}},
+		{Args: []string{"git", "push", "--tags"}},
+		{Args: []string{"go", "list", "-m",
+			"example.com/root@v2.4.0"}},
+	}
+	got = slices.DeleteFunc(got, func(c mock.Call) bool {
+		return c.Args[0] == "printenv"
+	})
+	if !cmp.Equal(want, got) {
+		t.Errorf("calls: -want +got\n%s", cmp.Diff(want, got))
+	}
+}
+
+func TestPromoteHeadAhead(t *testing.T) {
+	ctx := context.Background()
+	m := new(mock.Machine)
+	m.SetOS("linux")
+	m.SetArch("amd64")
+	sh := command.Shell(m, "git")
+	swap(t, &golang.Local, sh)
+	m.Return(strings.NewReader("v1.4.0-rc.2\n"), "git", "describe")
+	m.Return(strings.NewReader("0a1b2c3\n"),
+		"git", "rev-parse", "v1.4.0-rc.2^{commit}")
+
+	if err := promote(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	got := mock.Calls(m, "git", "tag")
+	want := []mock.Call{
+		{Args: []string{"git", "tag", "v1.4.0", "0a1b2c3"}},
+	}
+	if !cmp.Equal(want, got) {
+		t.Errorf("git tag calls: -want +got\n%s", cmp.Diff(want, got))
+	}
+}
+
+func TestProxyPingNested(t *testing.T) {
+	ctx := context.Background()
+	m := new(mock.Machine)
+	m.SetOS("linux")
+	m.SetArch("amd64")
+	sh := command.Shell(m, "go", "git")
+	swap(t, &golang.Build, sh)
+	swap(t, &golang.Local, sh)
+	if err := sh.WriteFile(ctx, "sub/go.mod", nil); err != nil {
+		t.Fatal(err)
+	}
+	m.Return(command.Fail(&command.Error{Code: 1}),
+		"git", "check-ignore")
+	m.Return(strings.NewReader("example.com/root/sub\n"),
+		"go", "-C", "sub", "list", "-m")
+	m.Return(strings.NewReader("sub/v1.2.0\n"), "git", "describe",
+		"--exact-match", "--tags", "--match", "sub/v[0-9]*")
+
+	if err := (Ops{}).ProxyPing(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	got := mock.Calls(m, "go", "list", "-m", "example.com/root/sub@v1.2.0")
+	if len(got) != 1 {
+		t.Errorf("proxy was not asked for sub/v1.2.0: %v", mock.Calls(m))
+	}
+}
+
+func TestPingRetries(t *testing.T) {
+	ctx := context.Background()
+	m := new(mock.Machine)
+	m.SetOS("linux")
+	m.SetArch("amd64")
+	sh := command.Shell(m, "go")
+	swap(t, &golang.Build, sh)
+	swap(t, &proxyPollInterval, time.Millisecond)
+	args := []string{"go", "list", "-m", "example.com/a@v1.0.0"}
+	m.Return(command.Fail(&command.Error{Code: 1}), args...)
+	m.Return(strings.NewReader("example.com/a v1.0.0\n"), args...)
+
+	if err := ping(ctx, "example.com/a", "v1.0.0"); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := len(mock.Calls(m, args...)); got != 2 {
+		t.Errorf("got %d go list calls, want 2", got)
+	}
+}
+
+func TestPingTimeout(t *testing.T) {
+	ctx := context.Background()
+	m := new(mock.Machine)
+	sh := command.Shell(m, "go")
+	swap(t, &golang.Build, sh)
+	swap(t, &proxyPollInterval, time.Millisecond)
+	swap(t, &ProxyTimeout, 20*time.Millisecond)
+	m.Return(command.Fail(&command.Error{Code: 1}),
+		"go", "list", "-m", "example.com/a@v1.0.0")
+
+	err := ping(ctx, "example.com/a", "v1.0.0")
+	if err == nil {
+		t.Fatal("ping() should fail when the proxy never responds")
+	}
+	if !strings.Contains(err.Error(), "proxy did not report") {
+		t.Errorf("error = %v, want 'proxy did not report'", err)
+	}
+}
+
+func TestParseRetract(t *testing.T) {
+	tests := []struct {
+		spec      string
+		dir       string
+		low, high string
+	}{
+		{"v1.2.3", ".", "v1.2.3", "v1.2.3"},
+		{"sub/mod/v1.2.3", "sub/mod", "v1.2.3", "v1.2.3"},
+		{"[v1.2.0, v1.2.3]", ".", "v1.2.0", "v1.2.3"},
+		{"[sub/v0.1.0,sub/v0.2.0]", "sub", "v0.1.0", "v0.2.0"},
+		{"./sub/v0.1.0", "sub", "v0.1.0", "v0.1.0"},
+	}
+	for _, tt := range tests {
+		dir, vi, err := parseRetract(tt.spec)
+		if err != nil {
+			t.Errorf("parseRetract(%q) err: %v", tt.spec, err)
+			continue
+		}
+		if dir != tt.dir || vi.Low != tt.low || vi.High != tt.high {
+			t.Errorf("parseRetract(%q) = %q, [%s, %s], "+
+				"want %q, [%s, %s]", tt.spec, dir, vi.Low, vi.High,
+				tt.dir, tt.low, tt.high)
+		}
+	}
+	for _, spec := range []string{
+		"latest", "[v1.2.3]", "[v2.0.0, v1.0.0]", "[a/v1.0.0, b/v1.1.0]",
+	} {
+		if _, _, err := parseRetract(spec); err == nil {
+			t.Errorf("parseRetract(%q) should fail", spec)
+		}
+	}
+}
+
+func TestAddRetract(t *testing.T) {
+	gomod := []byte("module example.com/a\n\ngo 1.25\n")
+
+	got, err := addRetract(gomod,
+		modfile.VersionInterval{Low: "v1.2.3", High: "v1.2.3"},
+		"Published with a broken API.")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := "module example.com/a\n\ngo 1.25\n\n" +
+		"// Published with a broken API.\nretract v1.2.3\n"
+	if string(got) != want {
+		t.Errorf("addRetract(): -want +got\n%s",
+			cmp.Diff(want, string(got)))
+	}
+}
@@ -0,0 +1,242 @@
+package golib
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+
+	"labs.lesiw.io/ops/golang"
+	"lesiw.io/fs/path"
+)
+
+// module is a Go module in this repository.
+type module struct {
+	dir     string   // Directory, relative to the repository root.
+	path    string   // Module path.
+	deps    []string // Paths of required modules from this repository.
+	tag     string   // Latest release tag, or "" if there is none.
+	changed bool     // Whether dir changed since tag.
+	version string   // New version, once bumped.
+}
+
+// prefix returns the tag prefix for m.
+func (m *module) prefix() string {
+	return tagPrefix(m.dir)
+}
+
+// tagPrefix returns the tag prefix for the module in dir,
+// such as "sub/mod/". The root module's tags have no prefix.
+func tagPrefix(dir string) string {
+	if dir = moduleDir(dir); dir == "." {
+		return ""
+	}
+	return dir + "/"
+}
+
+// moduleDir returns dir, relative to the repository root, in the form
+// used by nested module tags: "sub/mod" rather than "./sub/mod".
+// The root module's directory is ".".
+func moduleDir(dir string) string {
+	return strings.TrimPrefix(path.Clean(dir), "./")
+}
+
+// contains reports whether dir is nested inside m.
+func (m *module) contains(dir string) bool {
+	return dir != m.dir &&
+		(m.dir == "." || strings.HasPrefix(dir, m.dir+"/"))
+}
+
+var bumpVersion = golang.BumpVersion
+
+// loadModules returns the releasable modules in this repository,
+// ordered so that each module comes after the modules it requires.
+func loadModules(ctx context.Context) ([]*module, error) {
+	dirs, err := golang.Modules(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("find modules: %w", err)
+	}
+	var mods []*module
+	for _, dir := range dirs {
+		if hidden(dir) {
+			continue
+		}
+		dir = moduleDir(dir)
+		out, err := golang.Build.Read(ctx,
+			"go", "-C", dir, "mod", "edit", "-json")
+		if err != nil {
+			return nil, fmt.Errorf("read %s/go.mod: %w", dir, err)
+		}
+		var gomod struct {
+			Module  struct{ Path string }
+			Require []struct{ Path string }
+		}
+		if err := json.Unmarshal([]byte(out), &gomod); err != nil {
+			return nil, fmt.Errorf("parse %s/go.mod: %w", dir, err)
+		}
+		m := &module{dir: dir, path: gomod.Module.Path}
+		for _, req := range gomod.Require {
+			m.deps = append(m.deps, req.Path)
+		}
+		mods = append(mods, m)
+	}
+	local := make(map[string]bool)
+	for _, m := range mods {
+		local[m.path] = true
+	}
+	for _, m := range mods {
+		m.deps = slices.DeleteFunc(m.deps, func(dep string) bool {
+			return !local[dep]
+		})
+	}
+	for _, m := range mods {
+		if err := m.diff(ctx, mods); err != nil {
+			return nil, err
+		}
+	}
+	return sortModules(mods)
+}
+
+// diff sets the latest release tag of m
+// and whether m has changed since that release.
+// The root module is always considered changed.
+func (m *module) diff(ctx context.Context, mods []*module) error {
+	m.tag, _ = latestTag(ctx, m.prefix(), false)
+	if m.dir == "." || m.tag == "" {
+		m.changed = true
+		return nil
+	}
+	args := []string{"git", "diff", "--name-only", m.tag, "HEAD",
+		"--", m.dir}
+	for _, other := range mods {
+		if m.contains(other.dir) {
+			args = append(args, ":(exclude)"+other.dir)
+		}
+	}
+	out, err := golang.Local.Read(ctx, args...)
+	if err != nil {
+		return fmt.Errorf("diff %s since %s: %w", m.dir, m.tag, err)
+	}
+	m.changed = out != ""
+	return nil
+}
+
+// sortModules orders mods so that dependencies come first.
+// Independent modules are ordered by directory.
+func sortModules(mods []*module) ([]*module, error) {
+	slices.SortFunc(mods, func(a, b *module) int {
+		return cmp.Compare(a.dir, b.dir)
+	})
+	byPath := make(map[string]*module)
+	for _, m := range mods {
+		byPath[m.path] = m
+	}
+	var (
+		sorted   []*module
+		visit    func(m *module) error
+		done     = make(map[*module]bool)
+		visiting = make(map[*module]bool)
+	)
+	visit = func(m *module) error {
+		if done[m] {
+			return nil
+		}
+		if visiting[m] {
+			return fmt.Errorf("module dependency cycle at %s", m.path)
+		}
+		visiting[m] = true
+		for _, dep := range m.deps {
+			if err := visit(byPath[dep]); err != nil {
+				return err
+			}
+		}
+		done[m] = true
+		sorted = append(sorted, m)
+		return nil
+	}
+	for _, m := range mods {
+		if err := visit(m); err != nil {
+			return nil, err
+		}
+	}
+	return sorted, nil
+}
+
+// bumpModules tags a new version of each changed module in order.
+// A module that requires a newly tagged module is updated to require it,
+// committed, and then tagged as well.
+func bumpModules(ctx context.Context, mods []*module) error {
+	byPath := make(map[string]*module)
+	for _, m := range mods {
+		byPath[m.path] = m
+	}
+	for _, m := range mods {
+		var bumped []*module
+		for _, dep := range m.deps {
+			if d := byPath[dep]; d.version != "" {
+				bumped = append(bumped, d)
+			}
+		}
+		if !m.changed && len(bumped) == 0 {
+			continue
+		}
+		cur := cmp.Or(strings.TrimPrefix(m.tag, m.prefix()), "v0.0.0")
+		version, err := bumpVersion(ctx, cur)
+		if err != nil {
+			return fmt.Errorf("bump %s: %w", m.dir, err)
+		}
+		if len(bumped) > 0 {
+			if err := require(ctx, m, bumped); err != nil {
+				return err
+			}
+		}
+		if err := tag(ctx, m.prefix()+version); err != nil {
+			return err
+		}
+		m.version = version
+		if golang.IsDryRun(ctx) {
+			continue
+		}
+		// Warm the proxy so dependents can require this version.
+		if err := ping(ctx, m.path, version); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// require updates m to require the new versions of deps
+// and commits the result.
+func require(ctx context.Context, m *module, deps []*module) error {
+	var reqs []string
+	for _, d := range deps {
+		req := d.path + "@" + d.version
+		err := golang.Mutate(ctx, golang.Build,
+			"go", "-C", m.dir, "get", req)
+		if err != nil {
+			return fmt.Errorf("require %s in %s: %w", req, m.dir, err)
+		}
+		reqs = append(reqs, req)
+	}
+	err := golang.Mutate(ctx, golang.Local, "git", "add",
+		path.Join(m.dir, "go.mod"), path.Join(m.dir, "go.sum"))
+	if err != nil {
+		return err
+	}
+	return golang.Mutate(ctx, golang.Local, "git", "commit", "-m",
+		fmt.Sprintf("%s: require %s", m.path, strings.Join(reqs, ", ")))
+}
+
+// hidden reports whether dir is ignored by the go command,
+// like the .ops module.
+func hidden(dir string) bool {
+	for elem := range strings.SplitSeq(dir, "/") {
+		if elem != "." && (strings.HasPrefix(elem, ".") ||
+			strings.HasPrefix(elem, "_")) {
+			return true
+		}
+	}
+	return false
+}
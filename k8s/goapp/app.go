@@ -3,10 +3,11 @@ package goapp
 import (
 	"cmp"
 	"context"
-	"encoding/base64"
 	"fmt"
-	"math/rand/v2"
+	"maps"
+	"os"
 	"regexp"
+	"slices"
 	"strings"
 	"sync"
 	"time"
@@ -14,14 +15,10 @@ import (
 	"labs.lesiw.io/ops/goapp"
 	"labs.lesiw.io/ops/golang"
 	"lesiw.io/command"
-	"lesiw.io/command/ctr"
 	"lesiw.io/command/sub"
 	"lesiw.io/command/sys"
-	"lesiw.io/defers"
 )
 
-var ctl = ctr.Ctl(sys.Machine())
-
 var getSpkez = sync.OnceValues(func() (command.Machine, error) {
 	ctx := context.Background()
 	sh := command.Shell(sys.Machine())
@@ -41,78 +38,123 @@ var getSpkez = sync.OnceValues(func() (command.Machine, error) {
 	return sub.Machine(sys.Machine(), "spkez"), nil
 })
 
-var getKubectl = sync.OnceValues(func() (command.Machine, error) {
-	ctx := context.Background()
-	spkez, err := getSpkez()
-	if err != nil {
-		return nil, err
-	}
-	m := ctr.Machine(sys.Machine(), "bitnami/kubectl", "--entrypoint", "")
-	defers.Add(func() { _ = command.Shutdown(context.Background(), m) })
-	_, err = command.Copy(
-		command.NewWriter(ctx, m,
-			"sh", "-c", "mkdir -p /.kube && cat > /.kube/config"),
-		command.NewReader(ctx, spkez, "get", "k8s/config"),
-	)
-	if err != nil {
-		return nil, fmt.Errorf("could not set kubeconfig: %w", err)
-	}
-	return sub.Machine(m, "kubectl"), nil
-})
-
-var getHelm = sync.OnceValues(func() (command.Machine, error) {
-	ctx := context.Background()
-	spkez, err := getSpkez()
-	if err != nil {
-		return nil, err
-	}
-	m := ctr.Machine(sys.Machine(), "alpine/helm", "--entrypoint", "")
-	defers.Add(func() { _ = command.Shutdown(context.Background(), m) })
-	_, err = command.Copy(
-		command.NewWriter(ctx, m,
-			"sh", "-c", "mkdir -p /root/.kube && cat > /root/.kube/config"),
-		command.NewReader(ctx, spkez, "get", "k8s/config"),
-	)
-	if err != nil {
-		return nil, fmt.Errorf("could not set kubeconfig: %w", err)
-	}
-	return m, nil
-})
-
 type Ops struct {
 	goapp.Ops
 
 	Postgres       bool   // Whether this app uses a PostgreSQL database.
 	Hostname       string // This application's public hostname, if it has one.
 	Memory         int    // Requested memory, in MB.
+	CPU            int    // Requested CPU, in millicores. Defaults to 100.
+	CPULimit       int    // CPU limit, in millicores, if any.
 	Port           int    // Listening port.
 	Scalable       bool   // Whether this application can scale.
 	ServiceAccount string // K8s service account.
-	K8sDefinitions string // Additional k8s definitions.
+	K8sDefinitions string // Additional k8s definitions, as a Helm template.
+
+	// Autoscaling of Scalable applications.
+	MinReplicas  int      // Minimum replicas. Defaults to 2.
+	MaxReplicas  int      // Maximum replicas. Defaults to 5.
+	Metrics      []Metric // Autoscaling targets. Defaults to 80% CPU.
+	MinAvailable int      // Pods kept through disruptions, if any.
+
+	// Deploy waits up to RolloutTimeout, by default 5 minutes, for the
+	// release to roll out, then runs SmokeTest if the application has a
+	// Port. The release is rolled back if either fails.
+	RolloutTimeout time.Duration
+	SmokeTest      *SmokeTest
+
+	// Whether Deploy asks for confirmation before destructive changes.
+	ConfirmDestructive bool
+
+	// Container probes. Those left nil check that Port accepts
+	// connections, if the application has a Port.
+	LivenessProbe  *Probe
+	ReadinessProbe *Probe
+	StartupProbe   *Probe
 
 	Env        map[string]string // Map of environment variables.
 	EnvSecrets map[string]string // Map of spkez secrets, exposed as env vars.
+
+	BackupDir  string // Local backup directory. Defaults to "backups".
+	BackupURL  string // S3 URL for backups, like s3://bucket/app.
+	BackupAuth string // spkez path of the AWS config used for BackupURL.
+
+	// Namespace to deploy to, which Deploy creates if it is missing.
+	// Defaults to the kubeconfig's namespace.
+	Namespace string
+
+	// Limits on the application's namespace, such as
+	// {"requests.cpu": "2"}, and the resources of containers that set none.
+	// They need a Namespace of the application's own.
+	Quota           map[string]string
+	DefaultRequests map[string]string
+	DefaultLimits   map[string]string
+
+	// Whether to only let the ingress controller and the Postgres cluster
+	// reach the application's pods. The ingress controller runs in
+	// IngressNamespace, which defaults to kube-system.
+	IsolateNetwork   bool
+	IngressNamespace string
+
+	// Namespace of the Postgres cluster, if not the application's.
+	PostgresNamespace string
+
+	// Length and alphabet of generated secrets, such as the Postgres
+	// password. Default to 32 letters and digits. Secrets must have at
+	// least 128 bits of entropy.
+	SecretLength   int
+	SecretAlphabet string
+
+	// Registry and repository that Deploy pushes images to, by default
+	// ctr.lesiw.dev and the application's name. The default registry is
+	// logged in to as ll. Others, such as a local registry at
+	// localhost:5000, are only logged in to if RegistryAuth is set.
+	Registry     string
+	Repository   string
+	RegistryUser string    // Registry user name.
+	RegistryAuth string    // spkez path of the registry password.
+	Tag          TagScheme // How images are tagged. Defaults to TagTimestamp.
+	Image        Image     // How images are built.
+
+	// Local directory of OSV vulnerability entries, such as a copy of the
+	// Go vulnerability database. Before pushing, Deploy checks the
+	// application's Go version and modules against it, and its images for
+	// bundled secrets. High severity findings stop the push unless
+	// SCAN_OVERRIDE is set in the environment.
+	VulnDB string
+
+	// Named environments, such as staging and production. Ops act on
+	// the environment named by ENVIRONMENT, if it is set.
+	Environments map[string]Environment
+
+	resolved bool    // Whether environ has been applied.
+	cluster  cluster // Where the environment runs.
+	suffix   string  // Release name suffix.
 }
 
 func (op Ops) Deploy(ctx context.Context) error {
-	goapp.Targets = []golang.Target{
-		{Goos: "linux", Goarch: "arm64"},
+	op, err := op.environ(ctx)
+	if err != nil {
+		return err
+	}
+	if op.ConfirmDestructive {
+		if err := op.confirmPlan(ctx); err != nil {
+			return err
+		}
+	}
+	if len(linuxTargets()) == 0 {
+		return fmt.Errorf("no linux targets in goapp.Targets")
 	}
-	if err := op.Build(ctx); err != nil {
+	if err := goapp.BuildTargets(ctx, op.Ops, linuxTargets()); err != nil {
 		return fmt.Errorf("could not build app: %w", err)
 	}
 	sh := command.Shell(sys.Machine())
-	err := sh.Rename(ctx,
-		"out/"+goapp.Name+"-linux-aarch64", "out/app")
-	if err != nil {
-		return fmt.Errorf("could not rename binary: %w", err)
-	}
 	img, err := op.createImage(ctx, sh)
 	if err != nil {
 		return fmt.Errorf("could not create container: %w", err)
 	}
 	if err := op.deployImage(ctx, img); err != nil {
-		return fmt.Errorf("could not create helm chart: %w", err)
+		return fmt.Errorf("could not deploy image: %w", err)
 	}
 	return nil
 }
@@ -126,29 +168,31 @@ func (op Ops) ForceDestroy(ctx context.Context) error {
 }
 
 func (op Ops) destroy(ctx context.Context, force bool) error {
+	op, err := op.environ(ctx)
+	if err != nil {
+		return err
+	}
 	if !force {
 		if err := op.Backup(ctx); err != nil {
 			return fmt.Errorf("could not backup the application: %w", err)
 		}
 	}
-	helm, err := getHelm()
+	helm, err := op.helm()
 	if err != nil {
 		return err
 	}
-	err = command.Exec(ctx, helm, "helm", "uninstall", goapp.Name)
+	err = command.Exec(ctx, helm, "helm", "uninstall", op.name())
 	if err != nil {
 		return fmt.Errorf("could not delete helm release: %w", err)
 	}
 	if op.Postgres {
-		kubectl, err := getKubectl()
+		kubectl, err := op.kubectl()
 		if err != nil {
 			return err
 		}
-		pg := sub.Machine(kubectl,
-			"exec", "postgres-1", "-c", "postgres", "--", "psql", "-c",
-		)
+		pg := sub.Machine(op.postgres(kubectl), "psql", "-c")
 		err = command.Exec(ctx, pg,
-			fmt.Sprintf("drop role %s;", goapp.Name))
+			"DROP ROLE "+quoteIdent(op.name())+";")
 		if err != nil {
 			return fmt.Errorf("could not drop postgres role: %w", err)
 		}
@@ -156,384 +200,450 @@ func (op Ops) destroy(ctx context.Context, force bool) error {
 	return nil
 }
 
-func (Ops) Backup(_ context.Context) error {
-	// TODO: Back up the application data.
-	return nil
-}
-
-func (Ops) Restore(_ context.Context) error {
-	// TODO: Restore the application data from backup.
-	return nil
-}
-
+// createImage pushes an image for each linux target and an index
+// of them, then returns the index's reference by digest.
 func (op Ops) createImage(
 	ctx context.Context, sh *command.Sh,
 ) (string, error) {
-	img := fmt.Sprintf("ctr.lesiw.dev/%s:%d",
-		goapp.Name, time.Now().Unix())
-	_, err := command.Copy(
-		command.NewWriter(ctx, ctl,
-			"import", "--change", `CMD ["/app"]`, "-", img),
-		sh.OpenBuffer(ctx, "out/"),
-	)
+	tag, err := op.tag(ctx)
 	if err != nil {
-		return "", fmt.Errorf("could not import container: %w", err)
+		return "", err
 	}
-	spkez, err := getSpkez()
+	reg, err := op.registry(ctx)
 	if err != nil {
 		return "", err
 	}
-	_, err = command.Copy(
-		command.NewWriter(ctx, ctl, "login",
-			"--password-stdin", "-u", "ll", "ctr.lesiw.dev"),
-		command.NewReader(ctx, spkez, "get", "ctr.lesiw.dev/auth"),
-	)
+	imgs, err := op.images(ctx, sh, reg)
+	if err != nil {
+		return "", err
+	}
+	findings, err := op.scan(ctx, sh, imgs)
+	if err != nil {
+		return "", err
+	}
+	if err := checkScan(ctx, os.Stdout, findings); err != nil {
+		return "", err
+	}
+	index, err := newIndex(imgs)
 	if err != nil {
-		return "", fmt.Errorf("could not docker login: %w", err)
+		return "", fmt.Errorf("could not create image index: %w", err)
+	}
+	list := op.repository() + ":" + tag
+	if golang.IsDryRun(ctx) {
+		fmt.Println("dry run: push", list)
+		return list, nil
+	}
+	for _, img := range imgs {
+		if err := pushImage(ctx, reg, op.repoName(), img); err != nil {
+			return "", fmt.Errorf("could not push %s image: %w",
+				img.platform, err)
+		}
 	}
-	if err := command.Exec(ctx, ctl, "push", img); err != nil {
-		return "", fmt.Errorf("could not push container: %w", err)
+	if err := reg.putManifest(ctx, op.repoName(), tag, index); err != nil {
+		return "", fmt.Errorf("could not push %s: %w", list, err)
 	}
-	return img, nil
+	return op.repository() + "@" + index.desc.Digest, nil
 }
 
-// Chart.yaml template.
-// 1: App
-const chartYaml = `apiVersion: v2
-name: %[1]s
-type: application
-version: 1.0.0
-`
-
-// Application chart for a singleton app.
-// 1: App
-// 2: Image
-// 3: Memory
-// 4: Port
-// 5: Additional container config
-// 6: Service account
-//
-// minReadySeconds gates the rolling update on a 20-second stay-alive
-// gap — covering lesiw.io/proc's 10-second StartupWindow plus the
-// 5-second StartupGrace force-exit timer plus a small buffer.
-const singleAppChart = `---
-apiVersion: apps/v1
-kind: StatefulSet
-metadata:
-  name: %[1]s
-spec:
-  replicas: 1
-  minReadySeconds: 20
-  selector:
-    matchLabels:
-      app: %[1]s
-  template:
-    metadata:
-      labels:
-        app: %[1]s
-    spec:
-      serviceAccountName: %[4]s
-      imagePullSecrets:
-        - name: regcred
-      containers:
-        - name: app
-          image: %[2]s
-          imagePullPolicy: IfNotPresent
-          resources:
-            requests:
-              memory: %[3]dMi
-            limits:
-              memory: %[3]dMi
-%[5]s
-`
-
-// Application chart for a scalable app.
-// 1: App
-// 2: Image
-// 3: Memory
-// 4: Port
-// 5: Additional container config
-// 6: Service account
-const scalableAppChart = `---
-apiVersion: apps/v1
-kind: Deployment
-metadata:
-  name: %[1]s
-spec:
-  replicas: 2
-  minReadySeconds: 20
-  strategy:
-    type: RollingUpdate
-    rollingUpdate:
-      maxSurge: 0
-      maxUnavailable: 1
-  selector:
-    matchLabels:
-      app: %[1]s
-  template:
-    metadata:
-      labels:
-        app: %[1]s
-    spec:
-      serviceAccountName: %[4]s
-      imagePullSecrets:
-        - name: regcred
-      containers:
-        - name: app
-          image: %[2]s
-          imagePullPolicy: IfNotPresent
-          resources:
-            requests:
-              memory: %[3]dMi
-            limits:
-              memory: %[3]dMi
-%[5]s
----
-apiVersion: autoscaling/v2
-kind: HorizontalPodAutoscaler
-metadata:
-  name: %[1]s
-spec:
-  scaleTargetRef:
-    apiVersion: apps/v1
-    kind: Deployment
-    name: %[1]s
-  minReplicas: 2
-  maxReplicas: 5
-  metrics:
-    - type: Resource
-      resource:
-        name: cpu
-        target:
-          type: Utilization
-          averageUtilization: 80
-`
-
-// Application chart partial: Postgres config.
-// 1: App
-const appPGChart = `
-            - name: PGHOST
-              value: postgres-rw
-            - name: PGUSER
-              value: %[1]s
-            - name: PGDATABASE
-              value: %[1]s
-            - name: PGPASSWORD
-              valueFrom:
-                secretKeyRef:
-                  name: %[1]s-db-secret
-                  key: secret
-`
-
-// Service chart.
-// 1: App
-// 2: Port
-const serviceChart = `---
-apiVersion: v1
-kind: Service
-metadata:
-  name: %[1]s
-spec:
-  ports:
-    - port: 80
-      protocol: TCP
-      targetPort: %[2]d
-  selector:
-    app: %[1]s
-`
-
-// Database chart.
-// 1: App
-// 2: Owner
-const databaseChart = `---
-apiVersion: postgresql.cnpg.io/v1
-kind: Database
-metadata:
-  name: %[1]s
-spec:
-  databaseReclaimPolicy: retain
-  name: %[1]s
-  owner: %[2]s
-  cluster:
-    name: postgres
-`
-
-// Ingress chart.
-// 1: App
-// 2: Hostname
-const ingressChart = `---
-apiVersion: cert-manager.io/v1
-kind: Certificate
-metadata:
-  name: %[2]s
-spec:
-  secretName: %[2]s
-  dnsNames:
-    - %[2]s
-  issuerRef:
-    name: cloudflare-issuer
-    kind: Issuer
----
-apiVersion: networking.k8s.io/v1
-kind: Ingress
-metadata:
-  name: %[1]s-ingress
-  annotations:
-    traefik.ingress.kubernetes.io/router.tls: "true"
-    traefik.ingress.kubernetes.io/frontend.entryPoints.websecure: websecure
-spec:
-  tls:
-    - hosts:
-      - %[2]s
-      secretName: %[2]s
-  rules:
-    - host: %[2]s
-      http:
-        paths:
-          - backend:
-              service:
-                name: %[1]s
-                port:
-                  number: 80
-            path: /
-            pathType: Prefix
-`
-
 func (op Ops) deployImage(ctx context.Context, img string) error {
-	helm, err := getHelm()
+	helm, err := op.helm()
 	if err != nil {
 		return err
 	}
-	err = command.Exec(ctx, helm, "mkdir", "-p", "/chart/templates")
-	if err != nil {
-		return fmt.Errorf("could not create chart directory: %w", err)
-	}
-	_, err = command.Copy(
-		command.NewWriter(ctx, helm,
-			"sh", "-c", "cat > /chart/Chart.yaml"),
-		strings.NewReader(fmt.Sprintf(chartYaml, goapp.Name)),
-	)
+	manifests, err := op.manifests(img)
 	if err != nil {
-		return fmt.Errorf("could not create Chart.yaml: %w", err)
-	}
-	ctrspec, err := op.k8sCtrSpec(ctx)
-	if err != nil {
-		return fmt.Errorf("could not create environment block: %w", err)
+		return err
 	}
-	var tmpl strings.Builder
-	w := errWriter{w: &tmpl}
-	args := []any{
-		goapp.Name, img, cmp.Or(op.Memory, 32),
-		cmp.Or(op.ServiceAccount, "default"), ctrspec,
+	if err := op.createNamespace(ctx); err != nil {
+		return err
 	}
-	if op.Scalable {
-		w.Printf(scalableAppChart, args...)
-	} else {
-		w.Printf(singleAppChart, args...)
+	if err := op.validate(ctx, manifests); err != nil {
+		return fmt.Errorf("invalid chart: %w\n---\n%s", err, manifests)
 	}
-	if op.Port > 0 {
-		w.Printf(serviceChart, goapp.Name, op.Port)
+	if err := op.writeEnvSecrets(ctx); err != nil {
+		return fmt.Errorf("could not create environment secrets: %w", err)
 	}
 	if op.Postgres {
-		if err := createPostgresRole(ctx, goapp.Name); err != nil {
+		if err := op.createPostgresRole(ctx); err != nil {
 			return fmt.Errorf("failed to create postgres role: %w", err)
 		}
-		w.Printf(databaseChart, goapp.Name, goapp.Name)
-	}
-	if op.Hostname != "" {
-		w.Printf(ingressChart, goapp.Name, op.Hostname)
-	}
-	w.Printf("%s", op.K8sDefinitions)
-	if w.err != nil {
-		return fmt.Errorf("could not build template: %w", w.err)
 	}
-	_, err = command.Copy(
-		command.NewWriter(ctx, helm,
-			"sh", "-c", "cat > /chart/templates/chart.yaml"),
-		strings.NewReader(tmpl.String()),
-	)
+	chart, err := op.chart(img)
 	if err != nil {
-		return fmt.Errorf("could not write chart template: %w", err)
+		return err
+	}
+	if err := writeChart(ctx, helm, "/chart", chart); err != nil {
+		return err
 	}
-	err = command.Exec(ctx, helm,
-		"helm", "upgrade", goapp.Name, "/chart",
+	err = golang.Mutate(ctx, helm,
+		"helm", "upgrade", op.name(), "/chart",
 		"--install", "--atomic")
 	if err != nil {
-		chart, readErr := command.Read(ctx, helm,
-			"cat", "/chart/templates/chart.yaml")
-		if readErr != nil {
-			chart = fmt.Sprintf("<error reading file: %s>", readErr)
-		}
 		return fmt.Errorf(
-			"could not helm install: %w\n---\nchart.yml:\n%s", err, chart)
+			"could not helm install: %w\n---\nchart.yml:\n%s",
+			err, manifests)
 	}
 	_ = command.Do(ctx, helm, "rm", "-rf", "/chart")
+	return op.verify(ctx)
+}
+
+// writeChart writes the files of chart to dir on helm.
+func writeChart(
+	ctx context.Context, helm command.Machine, dir string,
+	chart map[string]string,
+) error {
+	err := command.Exec(ctx, helm, "mkdir", "-p", dir+"/templates")
+	if err != nil {
+		return fmt.Errorf("could not create chart directory: %w", err)
+	}
+	for _, name := range slices.Sorted(maps.Keys(chart)) {
+		_, err = command.Copy(
+			command.NewWriter(ctx, helm,
+				"sh", "-c", "cat > "+dir+"/"+name),
+			strings.NewReader(chart[name]),
+		)
+		if err != nil {
+			return fmt.Errorf("could not write %s: %w", name, err)
+		}
+	}
 	return nil
 }
 
-func (op Ops) k8sCtrSpec(ctx context.Context) (string, error) {
-	var spec strings.Builder
+// chartTemplate is the path of the chart's only template.
+const chartTemplate = "templates/chart.yaml"
 
-	var env strings.Builder
+// chart returns the files of the application's Helm chart for img,
+// keyed by their path within the chart.
+// It does not need a cluster, registry or spkez.
+func (op Ops) chart(img string) (map[string]string, error) {
+	if _, err := op.definitions(); err != nil {
+		return nil, err
+	}
+	docs, err := op.objects(img)
+	if err != nil {
+		return nil, err
+	}
+	objects, err := marshalDocs(docs...)
+	if err != nil {
+		return nil, fmt.Errorf("could not build manifests: %w", err)
+	}
+	return chartFiles(objects, op.K8sDefinitions)
+}
+
+// chartFiles returns the files of a Helm chart that installs objects,
+// followed by defs, which Helm templates as usual.
+func chartFiles(objects, defs string) (map[string]string, error) {
+	meta, err := marshalDocs(chartMeta{
+		APIVersion: "v2",
+		Name:       goapp.Name,
+		Type:       "application",
+		Version:    "1.0.0",
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not build Chart.yaml: %w", err)
+	}
+	// The generated objects are final, so keep Helm from templating them.
+	tmpl := escapeTemplate(objects)
+	if strings.TrimSpace(defs) != "" {
+		tmpl += "---\n" + defs
+	}
+	return map[string]string{
+		"Chart.yaml":  meta,
+		chartTemplate: tmpl,
+	}, nil
+}
+
+// manifests returns the application's Kubernetes objects for img,
+// followed by K8sDefinitions, as a stream of YAML documents.
+// K8sDefinitions that use Helm template directives are left out,
+// since they are only Kubernetes objects once Helm renders them.
+func (op Ops) manifests(img string) (string, error) {
+	extra, err := op.definitions()
+	if err != nil {
+		return "", err
+	}
+	docs, err := op.objects(img)
+	if err != nil {
+		return "", err
+	}
+	s, err := marshalDocs(append(docs, extra...)...)
+	if err != nil {
+		return "", fmt.Errorf("could not build manifests: %w", err)
+	}
+	return s, nil
+}
+
+// definitions returns the objects in K8sDefinitions, or none if they use
+// Helm template directives.
+func (op Ops) definitions() ([]any, error) {
+	if strings.Contains(op.K8sDefinitions, "{{") {
+		return nil, nil
+	}
+	docs, err := parseDocs(op.K8sDefinitions)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse K8sDefinitions: %w", err)
+	}
+	return docs, nil
+}
+
+// objects returns the Kubernetes objects generated for the application.
+func (op Ops) objects(img string) ([]any, error) {
+	labels := map[string]string{"app": op.name()}
+	memory := fmt.Sprintf("%dMi", cmp.Or(op.Memory, 32))
+	ctr := container{
+		Name:            "app",
+		Image:           img,
+		ImagePullPolicy: "IfNotPresent",
+		Resources: resources{
+			Requests: map[string]string{
+				"cpu":    fmt.Sprintf("%dm", cmp.Or(op.CPU, 100)),
+				"memory": memory,
+			},
+			Limits: map[string]string{"memory": memory},
+		},
+		Env: op.env(),
+	}
+	if op.CPULimit > 0 {
+		ctr.Resources.Limits["cpu"] = fmt.Sprintf("%dm", op.CPULimit)
+	}
+	if err := op.probes(&ctr); err != nil {
+		return nil, err
+	}
+	pod := podTemplate{
+		Metadata: objectMeta{Labels: labels},
+		Spec: podSpec{
+			ServiceAccountName: cmp.Or(op.ServiceAccount, "default"),
+			ImagePullSecrets:   []reference{{Name: "regcred"}},
+			Containers:         []container{ctr},
+		},
+	}
+	// Pods only count as ready once the readiness probe passes.
+	// minReadySeconds then gates the rolling update on a 20-second
+	// stay-alive gap — covering lesiw.io/proc's 10-second StartupWindow
+	// plus the 5-second StartupGrace force-exit timer plus a small buffer.
+	spec := workloadSpec{
+		Replicas:        1,
+		MinReadySeconds: 20,
+		Selector:        labelSelector{MatchLabels: labels},
+		Template:        pod,
+	}
+	meta := objectMeta{Name: op.name()}
+	var docs []any
+	if op.Scalable {
+		replicas, _, err := op.replicas()
+		if err != nil {
+			return nil, err
+		}
+		hpa, err := op.autoscaler()
+		if err != nil {
+			return nil, err
+		}
+		spec.Replicas = replicas
+		spec.Strategy = &deploymentStrategy{
+			Type:          "RollingUpdate",
+			RollingUpdate: &rollingUpdate{MaxSurge: 0, MaxUnavailable: 1},
+		}
+		docs = append(docs, object[workloadSpec]{
+			APIVersion: "apps/v1",
+			Kind:       "Deployment",
+			Metadata:   meta,
+			Spec:       spec,
+		}, hpa)
+		if pdb := op.disruptionBudget(labels); pdb != nil {
+			docs = append(docs, pdb)
+		}
+	} else {
+		docs = append(docs, object[workloadSpec]{
+			APIVersion: "apps/v1",
+			Kind:       "StatefulSet",
+			Metadata:   meta,
+			Spec:       spec,
+		})
+	}
+	if op.Port > 0 {
+		docs = append(docs, object[serviceSpec]{
+			APIVersion: "v1",
+			Kind:       "Service",
+			Metadata:   meta,
+			Spec: serviceSpec{
+				Ports: []servicePort{{
+					Port:       80,
+					Protocol:   "TCP",
+					TargetPort: op.Port,
+				}},
+				Selector: labels,
+			},
+		})
+	}
+	if op.Postgres {
+		docs = append(docs, object[databaseSpec]{
+			APIVersion: "postgresql.cnpg.io/v1",
+			Kind:       "Database",
+			Metadata: objectMeta{
+				Name:      op.name(),
+				Namespace: op.PostgresNamespace,
+			},
+			Spec: databaseSpec{
+				DatabaseReclaimPolicy: "retain",
+				Name:                  op.name(),
+				Owner:                 op.name(),
+				Cluster:               reference{Name: "postgres"},
+			},
+		})
+	}
+	if op.Hostname != "" {
+		docs = append(docs, object[certificateSpec]{
+			APIVersion: "cert-manager.io/v1",
+			Kind:       "Certificate",
+			Metadata:   objectMeta{Name: op.Hostname},
+			Spec: certificateSpec{
+				SecretName: op.Hostname,
+				DNSNames:   []string{op.Hostname},
+				IssuerRef: issuerRef{
+					Name: "cloudflare-issuer",
+					Kind: "Issuer",
+				},
+			},
+		}, object[ingressSpec]{
+			APIVersion: "networking.k8s.io/v1",
+			Kind:       "Ingress",
+			Metadata: objectMeta{
+				Name: op.name() + "-ingress",
+				Annotations: map[string]string{
+					"traefik.ingress.kubernetes.io/router.tls": "true",
+					"traefik.ingress.kubernetes.io/" +
+						"frontend.entryPoints.websecure": "websecure",
+				},
+			},
+			Spec: ingressSpec{
+				TLS: []ingressTLS{{
+					Hosts:      []string{op.Hostname},
+					SecretName: op.Hostname,
+				}},
+				Rules: []ingressRule{{
+					Host: op.Hostname,
+					HTTP: httpIngressRule{Paths: []ingressPath{{
+						Backend: ingressBackend{Service: serviceBackend{
+							Name: op.name(),
+							Port: backendPort{Number: 80},
+						}},
+						Path:     "/",
+						PathType: "Prefix",
+					}}},
+				}},
+			},
+		})
+	}
+	isolation, err := op.isolation(labels)
+	if err != nil {
+		return nil, err
+	}
+	docs = append(docs, isolation...)
+	return docs, nil
+}
+
+// env returns the application container's environment variables.
+func (op Ops) env() []envVar {
+	var env []envVar
 	if op.Postgres {
-		env.WriteString(fmt.Sprintf(appPGChart, goapp.Name))
+		env = append(env,
+			envVar{Name: "PGHOST", Value: op.postgresHost()},
+			envVar{Name: "PGUSER", Value: op.name()},
+			envVar{Name: "PGDATABASE", Value: op.name()},
+			envVar{Name: "PGPASSWORD", ValueFrom: &envVarSource{
+				SecretKeyRef: &secretKeySelector{
+					Name: op.name() + "-db-secret",
+					Key:  "secret",
+				},
+			}},
+		)
 	}
-	for k, v := range op.Env {
-		env.WriteString(fmt.Sprintf("            - name: %s\n"+
-			"              value: %q\n", k, v))
+	for _, k := range slices.Sorted(maps.Keys(op.Env)) {
+		env = append(env, envVar{Name: k, Value: op.Env[k]})
+	}
+	for _, k := range slices.Sorted(maps.Keys(op.EnvSecrets)) {
+		env = append(env, envVar{Name: k, ValueFrom: &envVarSource{
+			SecretKeyRef: &secretKeySelector{
+				Name: secretName(op.EnvSecrets[k]),
+				Key:  "data",
+			},
+		}})
+	}
+	return env
+}
+
+// validate checks manifests against the cluster's API schemas,
+// including those of custom resources, with a server-side dry run.
+func (op Ops) validate(ctx context.Context, manifests string) error {
+	kubectl, err := op.kubectl()
+	if err != nil {
+		return err
 	}
-	for k, v := range op.EnvSecrets {
+	_, err = command.Copy(
+		command.NewWriter(ctx, kubectl,
+			"apply", "--server-side", "--force-conflicts",
+			"--dry-run=server", "--validate=strict", "-f", "-"),
+		strings.NewReader(manifests),
+	)
+	return err
+}
+
+// writeEnvSecrets copies EnvSecrets from spkez into Kubernetes secrets.
+func (op Ops) writeEnvSecrets(ctx context.Context) error {
+	for _, v := range op.EnvSecrets {
 		spkez, err := getSpkez()
 		if err != nil {
-			return "", err
+			return err
 		}
 		r, err := command.Read(ctx, spkez, "get", v)
 		if err != nil {
-			return "", fmt.Errorf("could not read secret %q: %w", v, err)
+			return fmt.Errorf("could not read secret %q: %w", v, err)
 		}
-		name := regexp.MustCompile(`[^a-zA-Z0-9]+`).
-			ReplaceAllString(v, ".")
-		if err := op.writeSecret(ctx, name, r); err != nil {
-			return "", fmt.Errorf("could not store secret %q: %w", v, err)
+		if err := op.writeSecret(ctx, secretName(v), r); err != nil {
+			return fmt.Errorf("could not store secret %q: %w", v, err)
 		}
-		env.WriteString(fmt.Sprintf("            - name: %s\n"+
-			"              valueFrom:\n"+
-			"                secretKeyRef:\n"+
-			"                  name: %s\n"+
-			"                  key: data\n", k, name))
-	}
-	if env.Len() > 0 {
-		spec.WriteString("          env:\n" + env.String())
 	}
+	return nil
+}
 
-	return spec.String(), nil
+// secretName returns the Kubernetes secret name for a spkez path.
+func secretName(v string) string {
+	return regexp.MustCompile(`[^a-zA-Z0-9]+`).ReplaceAllString(v, ".")
 }
 
 func (op Ops) writeSecret(ctx context.Context, k, v string) error {
-	kubectl, err := getKubectl()
+	kubectl, err := op.kubectl()
 	if err != nil {
 		return err
 	}
-	_, err = command.Copy(
-		command.NewWriter(ctx, kubectl, "apply", "-f", "-"),
-		strings.NewReader(fmt.Sprintf(secretCfg, k, "data", v)),
-	)
+	manifest, err := secretManifest(k, "data", v, nil)
+	if err != nil {
+		return err
+	}
+	err = golang.MutateFrom(ctx, kubectl,
+		strings.NewReader(manifest), "apply", "-f", "-")
 	if err != nil {
 		return fmt.Errorf("kubectl apply failed: %w", err)
 	}
 	return nil
 }
 
-const secretCfg = `apiVersion: v1
-kind: Secret
-metadata:
-  name: %s
-type: Opaque
-stringData:
-  %s: %s`
+// secretManifest returns an Opaque secret holding value under key.
+func secretManifest(
+	name, key, value string, annotations map[string]string,
+) (string, error) {
+	return marshalDocs(secret{
+		APIVersion: "v1",
+		Kind:       "Secret",
+		Metadata:   objectMeta{Name: name, Annotations: annotations},
+		Type:       "Opaque",
+		StringData: map[string]string{key: value},
+	})
+}
 
-func createPostgresRole(ctx context.Context, name string) error {
-	kubectl, err := getKubectl()
+func (op Ops) createPostgresRole(ctx context.Context) error {
+	name := op.name()
+	kubectl, err := op.kubectl()
 	if err != nil {
 		return err
 	}
@@ -541,80 +651,50 @@ func createPostgresRole(ctx context.Context, name string) error {
 	var secretPass string
 	err = command.Do(ctx, kubectl, "get", "secrets", secretName)
 	if err != nil {
-		secretPass = randStr(32)
-		_, err = command.Copy(
-			command.NewWriter(ctx, kubectl, "apply", "-f", "-"),
-			strings.NewReader(fmt.Sprintf(
-				secretCfg, secretName, "secret", secretPass)),
-		)
+		secretPass, err = op.newSecret()
+		if err != nil {
+			return err
+		}
+		manifest, err := secretManifest(
+			secretName, "secret", secretPass, generated)
+		if err != nil {
+			return err
+		}
+		err = golang.MutateFrom(ctx, kubectl,
+			strings.NewReader(manifest), "apply", "-f", "-")
 		if err != nil {
 			return fmt.Errorf("could not generate secret: %w", err)
 		}
 	} else {
-		encoded, err := command.Read(ctx, kubectl,
-			"get", "secret", secretName,
-			"-o", "jsonpath={.data.secret}",
-		)
+		stored, err := readSecret(ctx, kubectl, secretName)
 		if err != nil {
 			return fmt.Errorf("could not get postgres password: %w", err)
 		}
-		decoded, err := base64.StdEncoding.DecodeString(encoded)
-		if err != nil {
-			return fmt.Errorf("could not decode postgres password: %w", err)
+		secretPass = stored.password
+		if stored.weak() {
+			fmt.Printf("warning: the password in %s may be weak; "+
+				"replace it with RotateSecrets\n", secretName)
 		}
-		secretPass = string(decoded)
 	}
-	pg := sub.Machine(kubectl,
-		"exec", "postgres-1", "-c", "postgres", "--", "psql", "-c",
-	)
-	sql := `DO
-$do$
-BEGIN
+	pg := sub.Machine(op.postgres(kubectl), "psql", "-c")
+	if golang.IsDryRun(ctx) {
+		// Keep the password out of the printed command.
+		secretPass = "********"
+	}
+	body := fmt.Sprintf(`BEGIN
    IF EXISTS (
       SELECT FROM pg_catalog.pg_roles
-      WHERE rolname = '%[1]s') THEN
+      WHERE rolname = %[1]s) THEN
 
-      RAISE NOTICE 'Role "%[1]s" already exists. Skipping.';
+      RAISE NOTICE 'Role %% already exists. Skipping.', %[1]s;
    ELSE
-      CREATE ROLE %[1]s LOGIN PASSWORD '%[2]s';
+      CREATE ROLE %[2]s LOGIN PASSWORD %[3]s;
    END IF;
 END
-$do$;
-`
-	// I'd welcome some sanitization here.
-	// Most PostgreSQL libraries don't expose their sanitization methods,
-	// and it would be nice to keep any import used here lightweight
-	// since I only need to run this single query.
-	// goapp.Name is trusted in any case and could already be used
-	// for k8s config injection and other terrible things,
-	// so this isn't an immediate concern.
-	err = command.Exec(ctx, pg, fmt.Sprintf(sql, name, secretPass))
+`, quoteLiteral(name), quoteIdent(name), quoteLiteral(secretPass))
+	err = golang.Mutate(ctx, pg, "DO\n"+dollarQuote(body)+";\n")
 	if err != nil {
 		return fmt.Errorf("could not create role: %w", err)
 	}
 	return nil
 }
-
-const (
-	alphabet = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
-)
-
-func randStr(n int) string {
-	var b strings.Builder
-	for range n {
-		b.WriteByte(alphabet[rand.IntN(len(alphabet))])
-	}
-	return b.String()
-}
-
-type errWriter struct {
-	w   interface{ WriteString(string) (int, error) }
-	err error
-}
-
-func (w *errWriter) Printf(format string, a ...any) {
-	if w.err != nil {
-		return
-	}
-	_, w.err = w.w.WriteString(fmt.Sprintf(format, a...))
-}
@@ -0,0 +1,200 @@
+package goapp
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"go.yaml.in/yaml/v3"
+
+	"labs.lesiw.io/ops/goapp"
+)
+
+func swap[T any](t *testing.T, ptr *T, val T) {
+	t.Helper()
+	old := *ptr
+	*ptr = val
+	t.Cleanup(func() { *ptr = old })
+}
+
+func TestChart(t *testing.T) {
+	swap(t, &goapp.Name, "app")
+	op := Ops{
+		Postgres: true,
+		Hostname: "app.example.com",
+		Port:     8080,
+		Env:      map[string]string{"B": "2", "A": "1"},
+		EnvSecrets: map[string]string{
+			"TOKEN": "app/token",
+		},
+	}
+
+	chart, err := op.chart("registry.test/app:1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := chart["Chart.yaml"]; !strings.Contains(got, "name: app\n") {
+		t.Errorf("Chart.yaml missing chart name:\n%s", got)
+	}
+	tmpl := chart[chartTemplate]
+	for _, want := range []string{
+		"kind: StatefulSet",
+		"image: registry.test/app:1",
+		"targetPort: 8080",
+		"kind: Database",
+		"host: app.example.com",
+		"name: app.token",
+		"readinessProbe:",
+	} {
+		if !strings.Contains(tmpl, want) {
+			t.Errorf("chart missing %q:\n%s", want, tmpl)
+		}
+	}
+	if strings.Index(tmpl, "name: A") > strings.Index(tmpl, "name: B") {
+		t.Errorf("env vars not sorted:\n%s", tmpl)
+	}
+	again, err := op.chart("registry.test/app:1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again[chartTemplate] != tmpl {
+		t.Error("chart is not deterministic")
+	}
+}
+
+func TestChartQuoting(t *testing.T) {
+	swap(t, &goapp.Name, "app")
+	op := Ops{Env: map[string]string{
+		"INJECT":   "x\n---\nkind: Secret",
+		"TEMPLATE": "{{ .Release.Name }}",
+		"NUMBER":   "0755",
+	}}
+
+	manifests, err := op.manifests("registry.test/app:1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	docs, err := parseDocs(manifests)
+	if err != nil {
+		t.Fatalf("manifests do not parse: %v\n%s", err, manifests)
+	}
+	if got, want := len(docs), 1; got != want {
+		t.Fatalf("got %d documents, want %d:\n%s", got, want, manifests)
+	}
+	var sts object[workloadSpec]
+	if err := docs[0].(*yaml.Node).Decode(&sts); err != nil {
+		t.Fatal(err)
+	}
+	got := sts.Spec.Template.Spec.Containers[0].Env
+	want := []envVar{
+		{Name: "INJECT", Value: "x\n---\nkind: Secret"},
+		{Name: "NUMBER", Value: "0755"},
+		{Name: "TEMPLATE", Value: "{{ .Release.Name }}"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("env = %#v, want %#v", got, want)
+	}
+
+	chart, err := chartFiles(manifests, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tmpl := chart[chartTemplate]; strings.Contains(tmpl, "{{ .") {
+		t.Errorf("chart template not escaped:\n%s", tmpl)
+	}
+}
+
+func TestChartK8sDefinitions(t *testing.T) {
+	swap(t, &goapp.Name, "app")
+	op := Ops{K8sDefinitions: `---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: extra
+data:
+  key: value
+---
+`}
+
+	manifests, err := op.manifests("registry.test/app:1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	docs, err := parseDocs(manifests)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(docs), 2; got != want {
+		t.Fatalf("got %d documents, want %d:\n%s", got, want, manifests)
+	}
+	if !strings.Contains(manifests, "kind: ConfigMap\n") {
+		t.Errorf("manifests missing K8sDefinitions:\n%s", manifests)
+	}
+}
+
+func TestChartTemplatedDefinitions(t *testing.T) {
+	swap(t, &goapp.Name, "app")
+	defs := `{{- if .Release.IsInstall }}
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: {{ .Release.Name }}-extra
+{{- end }}
+`
+	op := Ops{
+		Env:            map[string]string{"TEMPLATE": "{{ .Release.Name }}"},
+		K8sDefinitions: defs,
+	}
+
+	chart, err := op.chart("registry.test/app:1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifests, err := op.manifests("registry.test/app:1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tmpl := chart[chartTemplate]
+	if !strings.HasSuffix(tmpl, "---\n"+defs) {
+		t.Errorf("chart does not end with K8sDefinitions:\n%s", tmpl)
+	}
+	if strings.Contains(strings.TrimSuffix(tmpl, defs), "{{ .") {
+		t.Errorf("generated objects not escaped:\n%s", tmpl)
+	}
+	if strings.Contains(manifests, "ConfigMap") {
+		t.Errorf("manifests include templated K8sDefinitions:\n%s",
+			manifests)
+	}
+}
+
+func TestParseDocsUnnamed(t *testing.T) {
+	docs, err := parseDocs(`apiVersion: v1
+kind: List
+items: []
+---
+apiVersion: batch/v1
+kind: Job
+metadata:
+  generateName: migrate-
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(docs) != 2 {
+		t.Errorf("got %d documents, want 2", len(docs))
+	}
+}
+
+func TestParseDocsInvalid(t *testing.T) {
+	for _, s := range []string{
+		"kind: ConfigMap\nmetadata:\n  name: extra\n",
+		"- not\n- an\n- object\n",
+		"apiVersion: v1\nkind: [\n",
+	} {
+		if _, err := parseDocs(s); err == nil {
+			t.Errorf("parseDocs(%q) succeeded, want error", s)
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package goapp
+
+import (
+	"cmp"
+	"fmt"
+)
+
+// A Metric is a target that the autoscaler of a Scalable application
+// scales the number of pods to meet.
+//
+// Exactly one of Resource or Pods names the metric.
+type Metric struct {
+	Resource string // Container resource, either "cpu" or "memory".
+	Pods     string // Custom metric reported for each pod.
+
+	// Target average utilization, as a percentage of the request.
+	// Only valid for Resource metrics.
+	Utilization int
+
+	// Target average value, as a Kubernetes quantity such as 500m.
+	Value string
+}
+
+// defaultMetrics keeps average CPU use at 80% of the request.
+var defaultMetrics = []Metric{{Resource: "cpu", Utilization: 80}}
+
+// replicas returns the minimum and maximum replicas of a Scalable app.
+func (op Ops) replicas() (minimum, maximum int, err error) {
+	minimum = cmp.Or(op.MinReplicas, 2)
+	maximum = cmp.Or(op.MaxReplicas, max(minimum, 5))
+	if minimum > maximum {
+		return 0, 0, fmt.Errorf("MinReplicas %d is above MaxReplicas %d",
+			minimum, maximum)
+	}
+	if op.MinAvailable > 0 && op.MinAvailable >= minimum {
+		return 0, 0, fmt.Errorf(
+			"MinAvailable %d would block evictions with %d replicas",
+			op.MinAvailable, minimum)
+	}
+	return minimum, maximum, nil
+}
+
+// autoscaler returns the HorizontalPodAutoscaler of a Scalable app.
+func (op Ops) autoscaler() (any, error) {
+	minimum, maximum, err := op.replicas()
+	if err != nil {
+		return nil, err
+	}
+	metrics := op.Metrics
+	if len(metrics) == 0 {
+		metrics = defaultMetrics
+	}
+	spec := hpaSpec{
+		ScaleTargetRef: scaleTargetRef{
+			APIVersion: "apps/v1",
+			Kind:       "Deployment",
+			Name:       op.name(),
+		},
+		MinReplicas: minimum,
+		MaxReplicas: maximum,
+	}
+	for _, m := range metrics {
+		ms, err := m.spec()
+		if err != nil {
+			return nil, err
+		}
+		spec.Metrics = append(spec.Metrics, ms)
+	}
+	return object[hpaSpec]{
+		APIVersion: "autoscaling/v2",
+		Kind:       "HorizontalPodAutoscaler",
+		Metadata:   objectMeta{Name: op.name()},
+		Spec:       spec,
+	}, nil
+}
+
+func (m Metric) spec() (metricSpec, error) {
+	target := metricTarget{AverageValue: m.Value}
+	switch {
+	case m.Utilization > 0 && m.Value != "":
+		return metricSpec{}, fmt.Errorf(
+			"metric sets both Utilization and Value")
+	case m.Utilization > 0:
+		target.Type = "Utilization"
+		target.AverageUtilization = m.Utilization
+	case m.Value != "":
+		target.Type = "AverageValue"
+	default:
+		return metricSpec{}, fmt.Errorf("metric has no target")
+	}
+	switch {
+	case m.Resource != "" && m.Pods != "":
+		return metricSpec{}, fmt.Errorf("metric sets both Resource and Pods")
+	case m.Resource != "":
+		if m.Resource != "cpu" && m.Resource != "memory" {
+			return metricSpec{}, fmt.Errorf(
+				"unknown resource metric %q", m.Resource)
+		}
+		return metricSpec{
+			Type:     "Resource",
+			Resource: &resourceMetric{Name: m.Resource, Target: target},
+		}, nil
+	case m.Pods != "":
+		if m.Utilization > 0 {
+			return metricSpec{}, fmt.Errorf(
+				"pods metric %q cannot target utilization", m.Pods)
+		}
+		return metricSpec{
+			Type: "Pods",
+			Pods: &podsMetric{
+				Metric: metricIdentifier{Name: m.Pods},
+				Target: target,
+			},
+		}, nil
+	}
+	return metricSpec{}, fmt.Errorf("metric has no Resource or Pods")
+}
+
+// disruptionBudget returns the PodDisruptionBudget of a Scalable app,
+// or nil if it has no MinAvailable.
+func (op Ops) disruptionBudget(labels map[string]string) any {
+	if op.MinAvailable == 0 {
+		return nil
+	}
+	return object[pdbSpec]{
+		APIVersion: "policy/v1",
+		Kind:       "PodDisruptionBudget",
+		Metadata:   objectMeta{Name: op.name()},
+		Spec: pdbSpec{
+			MinAvailable: op.MinAvailable,
+			Selector:     labelSelector{MatchLabels: labels},
+		},
+	}
+}
@@ -0,0 +1,129 @@
+package goapp
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"go.yaml.in/yaml/v3"
+
+	"labs.lesiw.io/ops/goapp"
+)
+
+func TestAutoscaler(t *testing.T) {
+	swap(t, &goapp.Name, "app")
+	op := Ops{
+		Scalable:    true,
+		MinReplicas: 3,
+		MaxReplicas: 10,
+		Metrics: []Metric{
+			{Resource: "memory", Utilization: 70},
+			{Pods: "requests_per_second", Value: "100"},
+		},
+	}
+
+	hpa, err := op.autoscaler()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	spec := hpa.(object[hpaSpec]).Spec
+	if spec.MinReplicas != 3 || spec.MaxReplicas != 10 {
+		t.Errorf("replicas = %d-%d, want 3-10",
+			spec.MinReplicas, spec.MaxReplicas)
+	}
+	want := []metricSpec{{
+		Type: "Resource",
+		Resource: &resourceMetric{
+			Name: "memory",
+			Target: metricTarget{
+				Type:               "Utilization",
+				AverageUtilization: 70,
+			},
+		},
+	}, {
+		Type: "Pods",
+		Pods: &podsMetric{
+			Metric: metricIdentifier{Name: "requests_per_second"},
+			Target: metricTarget{Type: "AverageValue", AverageValue: "100"},
+		},
+	}}
+	if !cmp.Equal(want, spec.Metrics) {
+		t.Errorf("metrics: -want +got\n%s", cmp.Diff(want, spec.Metrics))
+	}
+}
+
+func TestAutoscalerDefaults(t *testing.T) {
+	swap(t, &goapp.Name, "app")
+
+	hpa, err := Ops{Scalable: true}.autoscaler()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	spec := hpa.(object[hpaSpec]).Spec
+	if spec.MinReplicas != 2 || spec.MaxReplicas != 5 {
+		t.Errorf("replicas = %d-%d, want 2-5",
+			spec.MinReplicas, spec.MaxReplicas)
+	}
+	if len(spec.Metrics) != 1 || spec.Metrics[0].Resource.Name != "cpu" {
+		t.Errorf("metrics = %+v, want cpu", spec.Metrics)
+	}
+}
+
+func TestAutoscalerInvalid(t *testing.T) {
+	swap(t, &goapp.Name, "app")
+	for _, op := range []Ops{
+		{MinReplicas: 6, MaxReplicas: 3},
+		{MinReplicas: 2, MinAvailable: 2},
+		{Metrics: []Metric{{Resource: "disk", Utilization: 50}}},
+		{Metrics: []Metric{{Resource: "cpu"}}},
+		{Metrics: []Metric{{Pods: "rps", Utilization: 50}}},
+		{Metrics: []Metric{{Resource: "cpu", Pods: "rps", Value: "1"}}},
+	} {
+		if _, err := op.autoscaler(); err == nil {
+			t.Errorf("autoscaler() with %+v succeeded, want error", op)
+		}
+	}
+}
+
+func TestChartScalable(t *testing.T) {
+	swap(t, &goapp.Name, "app")
+	op := Ops{
+		Scalable:     true,
+		CPU:          250,
+		CPULimit:     500,
+		MinReplicas:  3,
+		MinAvailable: 2,
+	}
+
+	res, err := splitManifests(mustManifests(t, op))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, k := range []resourceKey{
+		{"Deployment", "app"},
+		{"HorizontalPodAutoscaler", "app"},
+		{"PodDisruptionBudget", "app"},
+	} {
+		if _, ok := res[k]; !ok {
+			t.Errorf("chart missing %s", k)
+		}
+	}
+	var d object[workloadSpec]
+	err = yaml.Unmarshal([]byte(res[resourceKey{"Deployment", "app"}]), &d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.Spec.Replicas != 3 {
+		t.Errorf("replicas = %d, want 3", d.Spec.Replicas)
+	}
+	got := d.Spec.Template.Spec.Containers[0].Resources
+	want := resources{
+		Requests: map[string]string{"cpu": "250m", "memory": "32Mi"},
+		Limits:   map[string]string{"cpu": "500m", "memory": "32Mi"},
+	}
+	if !cmp.Equal(want, got) {
+		t.Errorf("resources: -want +got\n%s", cmp.Diff(want, got))
+	}
+}
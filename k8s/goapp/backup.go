@@ -0,0 +1,199 @@
+package goapp
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"lesiw.io/command"
+	"lesiw.io/command/ctr"
+	"lesiw.io/command/sys"
+	"lesiw.io/defers"
+	"lesiw.io/fs/path"
+)
+
+// backupTime is the timestamp layout used in backup names.
+const backupTime = "20060102T150405Z"
+
+// Backup dumps the application's database with pg_dump and stores it,
+// gzipped, as <app>-<timestamp>.sql.gz next to a .sha256 checksum file.
+// Backups go to BackupURL if it is set, otherwise to BackupDir.
+func (op Ops) Backup(ctx context.Context) error {
+	op, err := op.environ(ctx)
+	if err != nil {
+		return err
+	}
+	if !op.Postgres {
+		return nil
+	}
+	kubectl, err := op.kubectl()
+	if err != nil {
+		return err
+	}
+	store, err := op.backupStore(ctx)
+	if err != nil {
+		return fmt.Errorf("could not open backup store: %w", err)
+	}
+	name := fmt.Sprintf("%s-%s.sql.gz",
+		op.name(), time.Now().UTC().Format(backupTime))
+	dump := command.NewReader(ctx, op.postgres(kubectl),
+		"pg_dump", "--clean", "--if-exists", op.name(),
+	)
+	defer dump.Close()
+	if err := writeBackup(ctx, store, name, dump); err != nil {
+		return fmt.Errorf("could not write backup %s: %w", name, err)
+	}
+	fmt.Println("Backed up", op.name(), "to", name)
+	return nil
+}
+
+// writeBackup gzips dump into store as name,
+// then stores its checksum as name.sha256.
+// A backup without a checksum file is incomplete.
+func writeBackup(
+	ctx context.Context, store backupStore, name string, dump io.Reader,
+) error {
+	pr, pw := io.Pipe()
+	defer pr.Close()
+	go func() {
+		gz := gzip.NewWriter(pw)
+		_, err := io.Copy(gz, dump)
+		if err == nil {
+			err = gz.Close()
+		}
+		pw.CloseWithError(err)
+	}()
+	h := sha256.New()
+	if err := store.put(ctx, name, io.TeeReader(pr, h)); err != nil {
+		return err
+	}
+	sum := hex.EncodeToString(h.Sum(nil)) + "  " + name + "\n"
+	return store.put(ctx, name+".sha256", strings.NewReader(sum))
+}
+
+// backupStore is where backups are kept.
+type backupStore interface {
+	put(ctx context.Context, name string, r io.Reader) error
+	list(ctx context.Context) ([]string, error)
+	get(ctx context.Context, name string) (io.ReadCloser, error)
+}
+
+func (op Ops) backupStore(ctx context.Context) (backupStore, error) {
+	if op.BackupURL == "" {
+		return localStore{
+			sh:  command.Shell(sys.Machine()),
+			dir: op.backupDir(),
+		}, nil
+	}
+	if !strings.HasPrefix(op.BackupURL, "s3://") {
+		return nil, fmt.Errorf("unsupported backup URL: %s", op.BackupURL)
+	}
+	m := ctr.Machine(sys.Machine(), "amazon/aws-cli", "--entrypoint", "")
+	defers.Add(func() { _ = command.Shutdown(context.Background(), m) })
+	if op.BackupAuth != "" {
+		spkez, err := getSpkez()
+		if err != nil {
+			return nil, err
+		}
+		_, err = command.Copy(
+			command.NewWriter(ctx, m,
+				"sh", "-c", "mkdir -p /root/.aws && cat > /root/.aws/config"),
+			command.NewReader(ctx, spkez, "get", op.BackupAuth),
+		)
+		if err != nil {
+			return nil, fmt.Errorf("could not set aws config: %w", err)
+		}
+	}
+	return s3Store{m: m, url: strings.TrimSuffix(op.BackupURL, "/")}, nil
+}
+
+func (op Ops) backupDir() string {
+	if op.BackupDir == "" {
+		return "backups"
+	}
+	return op.BackupDir
+}
+
+type localStore struct {
+	sh  *command.Sh
+	dir string
+}
+
+func (s localStore) put(
+	ctx context.Context, name string, r io.Reader,
+) error {
+	if err := s.sh.MkdirAll(ctx, s.dir); err != nil {
+		return err
+	}
+	w, err := s.sh.Create(ctx, path.Join(s.dir, name))
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(w, r); err != nil {
+		_ = w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+func (s localStore) list(ctx context.Context) ([]string, error) {
+	var names []string
+	for entry, err := range s.sh.ReadDir(ctx, s.dir) {
+		if err != nil {
+			return nil, err
+		}
+		if !entry.IsDir() {
+			names = append(names, entry.Name())
+		}
+	}
+	return names, nil
+}
+
+func (s localStore) get(
+	ctx context.Context, name string,
+) (io.ReadCloser, error) {
+	return s.sh.Open(ctx, path.Join(s.dir, name))
+}
+
+type s3Store struct {
+	m   command.Machine
+	url string
+}
+
+func (s s3Store) put(ctx context.Context, name string, r io.Reader) error {
+	_, err := command.Copy(
+		command.NewWriter(ctx, s.m, "aws", "s3", "cp", "-", s.url+"/"+name),
+		r,
+	)
+	return err
+}
+
+func (s s3Store) list(ctx context.Context) ([]string, error) {
+	out, err := command.Read(ctx, s.m, "aws", "s3", "ls", s.url+"/")
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	scn := bufio.NewScanner(strings.NewReader(out))
+	for scn.Scan() {
+		// Objects are listed as: <date> <time> <size> <name>.
+		fields := strings.Fields(scn.Text())
+		if len(fields) == 4 {
+			names = append(names, fields[3])
+		}
+	}
+	return names, scn.Err()
+}
+
+func (s s3Store) get(
+	ctx context.Context, name string,
+) (io.ReadCloser, error) {
+	return command.NewReader(ctx, s.m,
+		"aws", "s3", "cp", s.url+"/"+name, "-"), nil
+}
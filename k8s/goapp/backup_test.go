@@ -0,0 +1,85 @@
+package goapp
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+type memStore map[string][]byte
+
+func (s memStore) put(_ context.Context, name string, r io.Reader) error {
+	buf, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	s[name] = buf
+	return nil
+}
+
+func (s memStore) list(context.Context) ([]string, error) {
+	var names []string
+	for name := range s {
+		names = append(names, name)
+	}
+	return names, nil
+}
+
+func (s memStore) get(
+	_ context.Context, name string,
+) (io.ReadCloser, error) {
+	return io.NopCloser(bytes.NewReader(s[name])), nil
+}
+
+func TestWriteBackup(t *testing.T) {
+	ctx := context.Background()
+	store := memStore{}
+	name := "app-20260102T030405Z.sql.gz"
+
+	err := writeBackup(ctx, store, name, strings.NewReader("SELECT 1;"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	gz, err := gzip.NewReader(bytes.NewReader(store[name]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dump, err := io.ReadAll(gz)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(dump), "SELECT 1;"; got != want {
+		t.Errorf("dump = %q, want %q", got, want)
+	}
+	sum := sha256.Sum256(store[name])
+	want := hex.EncodeToString(sum[:]) + "  " + name + "\n"
+	if got := string(store[name+".sha256"]); got != want {
+		t.Errorf("checksum = %q, want %q", got, want)
+	}
+}
+
+type errReader struct{ err error }
+
+func (r errReader) Read([]byte) (int, error) { return 0, r.err }
+
+func TestWriteBackupDumpFailure(t *testing.T) {
+	ctx := context.Background()
+	store := memStore{}
+	name := "app-20260102T030405Z.sql.gz"
+	dumpErr := errors.New("pg_dump failed")
+
+	err := writeBackup(ctx, store, name, errReader{dumpErr})
+	if !errors.Is(err, dumpErr) {
+		t.Errorf("writeBackup() = %v, want %v", err, dumpErr)
+	}
+	if _, ok := store[name+".sha256"]; ok {
+		t.Error("failed backup should not have a checksum")
+	}
+}
@@ -0,0 +1,193 @@
+package goapp
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"strings"
+	"sync"
+
+	"go.yaml.in/yaml/v3"
+
+	"labs.lesiw.io/ops/goapp"
+	"labs.lesiw.io/ops/golang"
+	"lesiw.io/command"
+	"lesiw.io/command/ctr"
+	"lesiw.io/command/sub"
+	"lesiw.io/command/sys"
+	"lesiw.io/defers"
+)
+
+// An Environment is a named copy of the application, such as staging,
+// with its own release. Fields left empty keep the values from Ops.
+type Environment struct {
+	Namespace   string            // Namespace to deploy to.
+	Kubeconfig  string            // spkez path of the kubeconfig.
+	Hostname    string            // Public hostname.
+	MinReplicas int               // Minimum replicas, if Scalable.
+	MaxReplicas int               // Maximum replicas, if Scalable.
+	Env         map[string]string // Environment variables, added to Env.
+	Suffix      string            // Release name suffix, as in app-staging.
+}
+
+// defaultKubeconfig is the spkez path of the default kubeconfig.
+const defaultKubeconfig = "k8s/config"
+
+// environ returns op with the environment named by ENVIRONMENT applied.
+// If ENVIRONMENT is empty, op is returned as is.
+func (op Ops) environ(ctx context.Context) (Ops, error) {
+	if op.resolved {
+		return op, nil
+	}
+	op.resolved = true
+	op.cluster.namespace = op.Namespace
+	name := golang.Local.Env(ctx, "ENVIRONMENT")
+	if name == "" {
+		return op, nil
+	}
+	e, ok := op.Environments[name]
+	if !ok {
+		return op, fmt.Errorf("unknown environment %q", name)
+	}
+	op.cluster.kubeconfig = e.Kubeconfig
+	if e.Namespace != "" {
+		op.cluster.namespace = e.Namespace
+	}
+	op.suffix = e.Suffix
+	if e.Hostname != "" {
+		op.Hostname = e.Hostname
+	}
+	if e.MinReplicas != 0 {
+		op.MinReplicas = e.MinReplicas
+	}
+	if e.MaxReplicas != 0 {
+		op.MaxReplicas = e.MaxReplicas
+	}
+	if len(e.Env) > 0 {
+		env := maps.Clone(op.Env)
+		if env == nil {
+			env = make(map[string]string)
+		}
+		maps.Copy(env, e.Env)
+		op.Env = env
+	}
+	return op, nil
+}
+
+// name returns the name of the application's release,
+// which also names its resources and database.
+func (op Ops) name() string {
+	if op.suffix == "" {
+		return goapp.Name
+	}
+	return goapp.Name + "-" + op.suffix
+}
+
+func (op Ops) kubectl() (command.Machine, error) {
+	return getKubectl(op.cluster)
+}
+
+func (op Ops) helm() (command.Machine, error) {
+	return getHelm(op.cluster)
+}
+
+// A cluster is where an environment runs.
+type cluster struct {
+	kubeconfig string // spkez path of the kubeconfig, if not the default.
+	namespace  string // Namespace, if not the kubeconfig's.
+}
+
+var getKubectl = onceEach(func(c cluster) (command.Machine, error) {
+	m := ctr.Machine(sys.Machine(), "bitnami/kubectl", "--entrypoint", "")
+	defers.Add(func() { _ = command.Shutdown(context.Background(), m) })
+	if err := c.configure(m, "/.kube"); err != nil {
+		return nil, err
+	}
+	return sub.Machine(m, "kubectl"), nil
+})
+
+var getHelm = onceEach(func(c cluster) (command.Machine, error) {
+	m := ctr.Machine(sys.Machine(), "alpine/helm", "--entrypoint", "")
+	defers.Add(func() { _ = command.Shutdown(context.Background(), m) })
+	if err := c.configure(m, "/root/.kube"); err != nil {
+		return nil, err
+	}
+	return m, nil
+})
+
+// configure writes the kubeconfig of c to dir/config on m.
+func (c cluster) configure(m command.Machine, dir string) error {
+	ctx := context.Background()
+	spkez, err := getSpkez()
+	if err != nil {
+		return err
+	}
+	path := c.kubeconfig
+	if path == "" {
+		path = defaultKubeconfig
+	}
+	cfg, err := command.Read(ctx, spkez, "get", path)
+	if err != nil {
+		return fmt.Errorf("could not get kubeconfig %s: %w", path, err)
+	}
+	if c.namespace != "" {
+		cfg, err = withNamespace(cfg, c.namespace)
+		if err != nil {
+			return fmt.Errorf("bad kubeconfig %s: %w", path, err)
+		}
+	}
+	_, err = command.Copy(
+		command.NewWriter(ctx, m,
+			"sh", "-c", "mkdir -p "+dir+" && cat > "+dir+"/config"),
+		strings.NewReader(cfg),
+	)
+	if err != nil {
+		return fmt.Errorf("could not set kubeconfig: %w", err)
+	}
+	return nil
+}
+
+// withNamespace sets the namespace of kubeconfig's current context.
+func withNamespace(kubeconfig, namespace string) (string, error) {
+	var cfg map[string]any
+	if err := yaml.Unmarshal([]byte(kubeconfig), &cfg); err != nil {
+		return "", err
+	}
+	current, _ := cfg["current-context"].(string)
+	contexts, _ := cfg["contexts"].([]any)
+	for _, c := range contexts {
+		c, _ := c.(map[string]any)
+		if c["name"] != current {
+			continue
+		}
+		kctx, ok := c["context"].(map[string]any)
+		if !ok {
+			break
+		}
+		kctx["namespace"] = namespace
+		out, err := yaml.Marshal(cfg)
+		return string(out), err
+	}
+	return "", fmt.Errorf("no current context %q", current)
+}
+
+// onceEach returns a function that calls f once for each distinct key
+// and returns its cached results after that.
+func onceEach[K comparable, V any](
+	f func(K) (V, error),
+) func(K) (V, error) {
+	var (
+		mu    sync.Mutex
+		calls = make(map[K]func() (V, error))
+	)
+	return func(k K) (V, error) {
+		mu.Lock()
+		call, ok := calls[k]
+		if !ok {
+			call = sync.OnceValues(func() (V, error) { return f(k) })
+			calls[k] = call
+		}
+		mu.Unlock()
+		return call()
+	}
+}
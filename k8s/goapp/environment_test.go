@@ -0,0 +1,145 @@
+package goapp
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"go.yaml.in/yaml/v3"
+
+	"labs.lesiw.io/ops/goapp"
+)
+
+func TestEnviron(t *testing.T) {
+	swap(t, &goapp.Name, "app")
+	t.Setenv("ENVIRONMENT", "staging")
+	op := Ops{
+		Hostname: "app.example.com",
+		Env:      map[string]string{"A": "1", "B": "2"},
+		Environments: map[string]Environment{
+			"staging": {
+				Namespace:  "app-staging",
+				Kubeconfig: "k8s/staging",
+				Hostname:   "staging.app.example.com",
+				Env:        map[string]string{"B": "3"},
+				Suffix:     "staging",
+			},
+		},
+	}
+
+	got, err := op.environ(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if name := got.name(); name != "app-staging" {
+		t.Errorf("name() = %q, want app-staging", name)
+	}
+	if got.Hostname != "staging.app.example.com" {
+		t.Errorf("Hostname = %q, want staging hostname", got.Hostname)
+	}
+	if got.Env["A"] != "1" || got.Env["B"] != "3" {
+		t.Errorf("Env = %v, want A=1 B=3", got.Env)
+	}
+	if op.Env["B"] != "2" {
+		t.Errorf("environ() modified the base Env: %v", op.Env)
+	}
+	want := cluster{kubeconfig: "k8s/staging", namespace: "app-staging"}
+	if got.cluster != want {
+		t.Errorf("cluster = %+v, want %+v", got.cluster, want)
+	}
+	again, err := got.environ(context.Background())
+	if err != nil || again.name() != "app-staging" {
+		t.Errorf("environ() is not idempotent: %q, %v", again.name(), err)
+	}
+
+	res, err := splitManifests(mustManifests(t, got))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := res[resourceKey{"StatefulSet", "app-staging"}]; !ok {
+		t.Errorf("chart missing StatefulSet/app-staging: %v", res)
+	}
+}
+
+func TestEnvironDefault(t *testing.T) {
+	swap(t, &goapp.Name, "app")
+	t.Setenv("ENVIRONMENT", "")
+
+	op, err := Ops{}.environ(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if op.name() != "app" || op.cluster != (cluster{}) {
+		t.Errorf("environ() = %q in %+v, want app in the default cluster",
+			op.name(), op.cluster)
+	}
+}
+
+func TestEnvironUnknown(t *testing.T) {
+	t.Setenv("ENVIRONMENT", "production")
+
+	if _, err := (Ops{}).environ(context.Background()); err == nil {
+		t.Error("environ() should fail on an unknown environment")
+	}
+}
+
+func TestWithNamespace(t *testing.T) {
+	cfg := `apiVersion: v1
+kind: Config
+current-context: prod
+contexts:
+- name: dev
+  context: {cluster: dev, user: dev}
+- name: prod
+  context: {cluster: prod, user: prod}
+`
+
+	out, err := withNamespace(cfg, "app")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got struct {
+		Contexts []struct {
+			Name    string
+			Context struct{ Namespace string }
+		}
+	}
+	if err := yaml.Unmarshal([]byte(out), &got); err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range got.Contexts {
+		want := ""
+		if c.Name == "prod" {
+			want = "app"
+		}
+		if c.Context.Namespace != want {
+			t.Errorf("context %s namespace = %q, want %q",
+				c.Name, c.Context.Namespace, want)
+		}
+	}
+	_, err = withNamespace(strings.Replace(cfg, "prod\n", "qa\n", 1), "app")
+	if err == nil {
+		t.Error("withNamespace() should fail without a current context")
+	}
+}
+
+func TestOnceEach(t *testing.T) {
+	calls := make(map[string]int)
+	get := onceEach(func(k string) (int, error) {
+		calls[k]++
+		return len(k), nil
+	})
+
+	for _, k := range []string{"a", "bb", "a", "bb", "a"} {
+		if n, _ := get(k); n != len(k) {
+			t.Errorf("get(%q) = %d, want %d", k, n, len(k))
+		}
+	}
+
+	if calls["a"] != 1 || calls["bb"] != 1 {
+		t.Errorf("calls = %v, want one per key", calls)
+	}
+}
@@ -0,0 +1,119 @@
+package goapp
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"labs.lesiw.io/ops/golang"
+	"lesiw.io/command"
+)
+
+// History lists the revisions of the application's release
+// with the image each one deployed.
+func (op Ops) History(ctx context.Context) error {
+	op, err := op.environ(ctx)
+	if err != nil {
+		return err
+	}
+	helm, err := op.helm()
+	if err != nil {
+		return err
+	}
+	revs, err := history(ctx, helm, op.name())
+	if err != nil {
+		return err
+	}
+	return printHistory(os.Stdout, revs)
+}
+
+// Rollback reverts the application's release to the revision given by
+// REVISION in the environment, or to the previous revision.
+func (op Ops) Rollback(ctx context.Context) error {
+	op, err := op.environ(ctx)
+	if err != nil {
+		return err
+	}
+	helm, err := op.helm()
+	if err != nil {
+		return err
+	}
+	args := []string{"helm", "rollback", op.name()}
+	if rev := golang.Local.Env(ctx, "REVISION"); rev != "" {
+		if _, err := strconv.Atoi(rev); err != nil {
+			return fmt.Errorf("bad revision %q: %w", rev, err)
+		}
+		args = append(args, rev)
+	}
+	args = append(args, "--wait")
+	if err := golang.Mutate(ctx, helm, args...); err != nil {
+		return fmt.Errorf("could not roll back %s: %w", op.name(), err)
+	}
+	return nil
+}
+
+// A revision is a revision of a Helm release.
+type revision struct {
+	Revision    int    `json:"revision"`
+	Updated     string `json:"updated"`
+	Status      string `json:"status"`
+	Description string `json:"description"`
+
+	image string
+}
+
+// history returns the revisions of the release, oldest first.
+func history(
+	ctx context.Context, helm command.Machine, release string,
+) ([]revision, error) {
+	out, err := command.Read(ctx, helm,
+		"helm", "history", release, "-o", "json")
+	if err != nil {
+		return nil, fmt.Errorf("could not get release history: %w", err)
+	}
+	var revs []revision
+	if err := json.Unmarshal([]byte(out), &revs); err != nil {
+		return nil, fmt.Errorf("could not parse release history: %w", err)
+	}
+	for i, rev := range revs {
+		manifest, err := command.Read(ctx, helm,
+			"helm", "get", "manifest", release,
+			"--revision", strconv.Itoa(rev.Revision))
+		if err != nil {
+			return nil, fmt.Errorf("could not get revision %d: %w",
+				rev.Revision, err)
+		}
+		res, err := splitManifests(manifest)
+		if err != nil {
+			return nil, fmt.Errorf("could not parse revision %d: %w",
+				rev.Revision, err)
+		}
+		revs[i].image = liveImage(res, release)
+	}
+	return revs, nil
+}
+
+func printHistory(w io.Writer, revs []revision) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	_, err := fmt.Fprintln(tw, "REVISION\tUPDATED\tSTATUS\tIMAGE\tDESCRIPTION")
+	if err != nil {
+		return err
+	}
+	for _, rev := range revs {
+		updated := rev.Updated
+		if t, err := time.Parse(time.RFC3339Nano, updated); err == nil {
+			updated = t.Local().Format(time.DateTime)
+		}
+		_, err := fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%s\n", rev.Revision,
+			updated, rev.Status, rev.image, rev.Description)
+		if err != nil {
+			return err
+		}
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,77 @@
+package goapp
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"labs.lesiw.io/ops/goapp"
+	"lesiw.io/command/mock"
+)
+
+func TestHistory(t *testing.T) {
+	swap(t, &goapp.Name, "app")
+	m := setupCluster(t)
+	m.Return(strings.NewReader(`[
+{"revision":1,"updated":"2026-01-02T03:04:05.6+00:00",
+ "status":"superseded","description":"Install complete"},
+{"revision":2,"updated":"2026-01-03T03:04:05.6+00:00",
+ "status":"deployed","description":"Upgrade complete"}
+]`), "helm", "history")
+	m.Return(strings.NewReader(
+		mustManifestsFor(t, Ops{}, "ctr.lesiw.dev/app:100")),
+		"helm", "get", "manifest", "app", "--revision", "1")
+	m.Return(strings.NewReader(
+		mustManifestsFor(t, Ops{Scalable: true}, "ctr.lesiw.dev/app:200")),
+		"helm", "get", "manifest", "app", "--revision", "2")
+
+	revs, err := history(context.Background(), m, "app")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(revs) != 2 {
+		t.Fatalf("got %d revisions, want 2", len(revs))
+	}
+	for i, want := range []string{
+		"ctr.lesiw.dev/app:100", "ctr.lesiw.dev/app:200",
+	} {
+		if got := revs[i].image; got != want {
+			t.Errorf("revision %d image = %q, want %q",
+				revs[i].Revision, got, want)
+		}
+	}
+	var buf strings.Builder
+	if err := printHistory(&buf, revs); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), "Upgrade complete") {
+		t.Errorf("history missing description:\n%s", buf.String())
+	}
+}
+
+func TestRollback(t *testing.T) {
+	swap(t, &goapp.Name, "app")
+	m := setupCluster(t)
+	t.Setenv("REVISION", "3")
+
+	if err := (Ops{}).Rollback(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	got := mock.Calls(m, "helm")
+	if len(got) != 1 || strings.Join(got[0].Args, " ") !=
+		"helm rollback app 3 --wait" {
+		t.Errorf("helm calls = %v, want rollback to 3", got)
+	}
+}
+
+func TestRollbackBadRevision(t *testing.T) {
+	swap(t, &goapp.Name, "app")
+	setupCluster(t)
+	t.Setenv("REVISION", "latest")
+
+	if err := (Ops{}).Rollback(context.Background()); err == nil {
+		t.Error("Rollback() should fail on a non-numeric revision")
+	}
+}
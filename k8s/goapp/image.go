@@ -0,0 +1,411 @@
+package goapp
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"maps"
+	"path"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"labs.lesiw.io/ops/goapp"
+	"labs.lesiw.io/ops/golang"
+	"lesiw.io/command"
+	"lesiw.io/command/sys"
+)
+
+// imageLayout is where BuildImage writes images.
+const imageLayout = "out/image"
+
+// BuildImage builds the application's images, as Deploy would, and
+// writes them as an OCI image layout to out/image instead of pushing
+// them. It needs no container runtime or registry credentials.
+func (op Ops) BuildImage(ctx context.Context) error {
+	if len(linuxTargets()) == 0 {
+		return fmt.Errorf("no linux targets in goapp.Targets")
+	}
+	if err := goapp.BuildTargets(ctx, op.Ops, linuxTargets()); err != nil {
+		return fmt.Errorf("could not build app: %w", err)
+	}
+	tag, err := op.tag(ctx)
+	if err != nil {
+		return err
+	}
+	sh := command.Shell(sys.Machine())
+	imgs, err := op.images(ctx, sh, nil)
+	if err != nil {
+		return err
+	}
+	findings, err := op.scan(ctx, sh, imgs)
+	if err != nil {
+		return err
+	}
+	for _, f := range findings {
+		fmt.Println("scan:", f)
+	}
+	if err := writeLayout(ctx, sh, imageLayout, tag, imgs); err != nil {
+		return fmt.Errorf("could not write image layout: %w", err)
+	}
+	fmt.Println("Wrote", op.repository()+":"+tag, "to", imageLayout)
+	return nil
+}
+
+// A TagScheme is how Deploy tags the images it pushes.
+type TagScheme string
+
+const (
+	TagTimestamp TagScheme = "timestamp" // Unix time of the build.
+	TagGitSHA    TagScheme = "git"       // Commit SHA of HEAD.
+	TagVersion   TagScheme = "version"   // Contents of goapp.Versionfile.
+)
+
+// defaultRegistry is used when Ops has no Registry.
+const defaultRegistry = "ctr.lesiw.dev"
+
+var validTag = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9_.-]{0,127}$`)
+
+// repository returns the repository that images are pushed to,
+// including the registry host.
+func (op Ops) repository() string {
+	return op.registryHost() + "/" + op.repoName()
+}
+
+func (op Ops) registryHost() string {
+	host, _ := op.registryRepo()
+	return host
+}
+
+func (op Ops) repoName() string {
+	_, repo := op.registryRepo()
+	return repo
+}
+
+// registryRepo returns the registry host and repository that images
+// are pushed to, normalized like base image references.
+func (op Ops) registryRepo() (host, repo string) {
+	return dockerHub(cmp.Or(op.Registry, defaultRegistry),
+		cmp.Or(op.Repository, goapp.Name))
+}
+
+// registryAuth returns the user name and spkez path of the password
+// to log in to the registry with, if it needs a login.
+func (op Ops) registryAuth() (user, auth string) {
+	if op.Registry == "" {
+		return "ll", defaultRegistry + "/auth"
+	}
+	return op.RegistryUser, op.RegistryAuth
+}
+
+// tag returns the tag of the image being built, following op.Tag.
+func (op Ops) tag(ctx context.Context) (string, error) {
+	var tag string
+	switch op.Tag {
+	case "", TagTimestamp:
+		tag = strconv.FormatInt(time.Now().Unix(), 10)
+	case TagGitSHA:
+		sha, err := golang.Local.Read(ctx,
+			"git", "rev-parse", "--short=12", "HEAD")
+		if err != nil {
+			return "", fmt.Errorf("could not get git commit: %w", err)
+		}
+		tag = strings.TrimSpace(sha)
+	case TagVersion:
+		buf, err := golang.Build.ReadFile(ctx, goapp.Versionfile)
+		if err != nil {
+			return "", fmt.Errorf("could not read %s: %w",
+				goapp.Versionfile, err)
+		}
+		tag = strings.TrimSpace(string(buf))
+	default:
+		return "", fmt.Errorf("unknown tag scheme %q", op.Tag)
+	}
+	if !validTag.MatchString(tag) {
+		return "", fmt.Errorf("bad image tag %q", tag)
+	}
+	return tag, nil
+}
+
+// registry returns a client of the registry that images are pushed to,
+// logged in if it needs a login.
+func (op Ops) registry(ctx context.Context) (*registry, error) {
+	user, auth := op.registryAuth()
+	if auth == "" {
+		return newRegistry(op.registryHost(), "", ""), nil
+	}
+	spkez, err := getSpkez()
+	if err != nil {
+		return nil, err
+	}
+	password, err := command.Read(ctx, spkez, "get", auth)
+	if err != nil {
+		return nil, fmt.Errorf("could not get registry password: %w", err)
+	}
+	return newRegistry(op.registryHost(), user, password), nil
+}
+
+// linuxTargets returns the linux targets in goapp.Targets,
+// which Deploy builds images for.
+func linuxTargets() []golang.Target {
+	var targets []golang.Target
+	for _, t := range goapp.Targets {
+		if t.Goos == "linux" {
+			targets = append(targets, t)
+		}
+	}
+	return targets
+}
+
+// platform returns the OCI platform of t.
+func platform(t golang.Target) ociPlatform {
+	p := ociPlatform{OS: t.Goos, Architecture: t.Goarch}
+	if t.Goarch == "arm" {
+		p.Variant = "v7" // The default GOARM when cross-compiling.
+	}
+	return p
+}
+
+func (p ociPlatform) String() string {
+	s := p.OS + "/" + p.Architecture
+	if p.Variant != "" {
+		s += "/" + p.Variant
+	}
+	return s
+}
+
+// An Image describes the container image that Deploy builds around the
+// application's binary, /app.
+type Image struct {
+	// Base image. Defaults to distroless's static image, which has
+	// CA certificates, time zone data and a nonroot user.
+	// Set it to scratch for an image with only the application.
+	// Targets that it has no image for, such as linux/386 for the default,
+	// are left out of the pushed image.
+	Base string
+
+	// Files to add, from a local path to a path in the image.
+	// Directories are added with their contents.
+	Files map[string]string
+
+	User    string   // User and group to run as. Defaults to nonroot.
+	Workdir string   // Working directory, if not /.
+	Args    []string // Arguments to the application.
+
+	// Labels to add to the OCI labels for the source repository,
+	// revision and version.
+	Labels map[string]string
+}
+
+const (
+	defaultBase = "gcr.io/distroless/static-debian12:nonroot"
+	defaultUser = "65532:65532" // The distroless nonroot user.
+)
+
+// imageLabels returns the labels of the application's images.
+func (op Ops) imageLabels(ctx context.Context) map[string]string {
+	labels := map[string]string{
+		"org.opencontainers.image.title": goapp.Name,
+	}
+	if url, err := golang.Local.Read(ctx,
+		"git", "remote", "get-url", "origin"); err == nil {
+		labels["org.opencontainers.image.source"] = strings.TrimSpace(url)
+	}
+	if sha, err := golang.Local.Read(ctx,
+		"git", "rev-parse", "HEAD"); err == nil {
+		labels["org.opencontainers.image.revision"] = strings.TrimSpace(sha)
+	}
+	if buf, err := golang.Build.ReadFile(ctx, goapp.Versionfile); err == nil {
+		labels["org.opencontainers.image.version"] =
+			strings.TrimSpace(string(buf))
+	}
+	maps.Copy(labels, op.Image.Labels)
+	return labels
+}
+
+// images builds an image for each linux target from the binaries in out/.
+// The base image is pulled with dst's credentials if it is in dst.
+// Targets that the base image has no image for are skipped.
+func (op Ops) images(
+	ctx context.Context, sh *command.Sh, dst *registry,
+) ([]ociImage, error) {
+	base := cmp.Or(op.Image.Base, defaultBase)
+	var from *registry
+	var ref imageRef
+	if base != "scratch" {
+		var err error
+		if ref, err = parseImageRef(base); err != nil {
+			return nil, err
+		}
+		if dst != nil && ref.host == dst.host {
+			from = dst
+		} else {
+			from = newRegistry(ref.host, "", "")
+		}
+	}
+	labels := op.imageLabels(ctx)
+	var imgs []ociImage
+	for _, t := range linuxTargets() {
+		p := platform(t)
+		var m ociManifest
+		cfg := imageConfig{RootFS: rootFS{Type: "layers"}}
+		if from != nil {
+			var err error
+			m, cfg, err = baseImage(ctx, from, ref, p)
+			if errors.Is(err, errNoPlatform) {
+				fmt.Printf("warning: skipping %s: %v\n", p, err)
+				continue
+			} else if err != nil {
+				return nil, fmt.Errorf("could not get base image %s: %w",
+					base, err)
+			}
+		}
+		files, err := op.layerFiles(ctx, sh, t)
+		if err != nil {
+			return nil, err
+		}
+		img, err := op.image(m, cfg, p, files, labels)
+		if err != nil {
+			return nil, fmt.Errorf("could not build %s image: %w", p, err)
+		}
+		img.from, img.fromRepo = from, ref.repo
+		img.files = files
+		imgs = append(imgs, img)
+	}
+	if len(imgs) == 0 {
+		return nil, fmt.Errorf("base image %s has no image for any target",
+			base)
+	}
+	return imgs, nil
+}
+
+// layerFiles returns the files that the image for t adds to its base:
+// the binary built for t and the Files of op.Image.
+func (op Ops) layerFiles(
+	ctx context.Context, sh *command.Sh, t golang.Target,
+) ([]layerFile, error) {
+	bin := "out/" + goapp.Name + "-" + t.Unames() + "-" + t.Unamer()
+	data, err := sh.ReadFile(ctx, bin)
+	if err != nil {
+		return nil, fmt.Errorf("could not read binary: %w", err)
+	}
+	files := []layerFile{{name: "app", mode: 0755, data: data}}
+	if dir := strings.Trim(path.Clean(op.Image.Workdir), "/."); dir != "" {
+		files = append(files, layerFile{name: dir, mode: fs.ModeDir | 0755})
+	}
+	for _, src := range slices.Sorted(maps.Keys(op.Image.Files)) {
+		added, err := addFiles(ctx, sh, src, op.Image.Files[src])
+		if err != nil {
+			return nil, fmt.Errorf("could not add %s: %w", src, err)
+		}
+		files = append(files, added...)
+	}
+	return files, nil
+}
+
+// addFiles returns the file or directory at src as layer files at dst.
+func addFiles(
+	ctx context.Context, sh *command.Sh, src, dst string,
+) ([]layerFile, error) {
+	dst = strings.Trim(path.Clean(dst), "/")
+	info, err := sh.Stat(ctx, src)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		data, err := sh.ReadFile(ctx, src)
+		if err != nil {
+			return nil, err
+		}
+		return []layerFile{{name: dst, mode: info.Mode(), data: data}}, nil
+	}
+	var files []layerFile
+	if dst != "" && dst != "." {
+		files = append(files, layerFile{name: dst, mode: info.Mode()})
+	}
+	for e, err := range sh.Walk(ctx, src, 0) {
+		if err != nil {
+			return nil, err
+		}
+		rel, err := filepath.Rel(src, e.Path())
+		if err != nil || rel == "." {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			return nil, err
+		}
+		f := layerFile{
+			name: path.Join(dst, filepath.ToSlash(rel)),
+			mode: info.Mode(),
+		}
+		if !e.IsDir() {
+			if f.data, err = sh.ReadFile(ctx, e.Path()); err != nil {
+				return nil, err
+			}
+		}
+		files = append(files, f)
+	}
+	return files, nil
+}
+
+// image returns the image for platform p that adds files to the base
+// image with manifest m and config cfg.
+func (op Ops) image(
+	m ociManifest, cfg imageConfig, p ociPlatform, files []layerFile,
+	labels map[string]string,
+) (ociImage, error) {
+	layer, diffID, err := newLayer(files)
+	if err != nil {
+		return ociImage{}, err
+	}
+	cfg.Architecture, cfg.OS, cfg.Variant = p.Architecture, p.OS, p.Variant
+	c := &cfg.Config
+	c.User = cmp.Or(op.Image.User, defaultUser)
+	if op.Image.Workdir != "" {
+		c.WorkingDir = op.Image.Workdir
+	}
+	if op.Port > 0 {
+		c.ExposedPorts = map[string]struct{}{
+			fmt.Sprintf("%d/tcp", op.Port): {},
+		}
+	}
+	c.Labels = maps.Clone(c.Labels)
+	if c.Labels == nil {
+		c.Labels = make(map[string]string)
+	}
+	maps.Copy(c.Labels, labels)
+	c.Entrypoint = []string{"/app"}
+	c.Cmd = op.Image.Args
+	cfg.RootFS.DiffIDs = append(cfg.RootFS.DiffIDs, diffID)
+	if len(cfg.History) > 0 {
+		cfg.History = append(cfg.History, layerHistory{
+			CreatedBy: "labs.lesiw.io/ops/k8s/goapp",
+		})
+	}
+	config, err := jsonBlob(mediaTypeConfig, cfg)
+	if err != nil {
+		return ociImage{}, err
+	}
+	manifest, err := jsonBlob(mediaTypeManifest, ociManifest{
+		SchemaVersion: 2,
+		MediaType:     mediaTypeManifest,
+		Config:        config.desc,
+		Layers:        append(slices.Clip(m.Layers), layer.desc),
+	})
+	if err != nil {
+		return ociImage{}, err
+	}
+	return ociImage{
+		platform: p,
+		manifest: manifest,
+		config:   config,
+		layers:   []blob{layer},
+		base:     m.Layers,
+	}, nil
+}
@@ -0,0 +1,427 @@
+package goapp
+
+import (
+	"context"
+	"encoding/json"
+	"maps"
+	"slices"
+	"strings"
+	"testing"
+
+	"lesiw.io/command"
+	"lesiw.io/command/mock"
+
+	"labs.lesiw.io/ops/goapp"
+	"labs.lesiw.io/ops/golang"
+)
+
+func TestRepository(t *testing.T) {
+	swap(t, &goapp.Name, "app")
+	tests := []struct {
+		op         Ops
+		repo       string
+		user, auth string
+	}{{
+		op:   Ops{},
+		repo: "ctr.lesiw.dev/app",
+		user: "ll",
+		auth: "ctr.lesiw.dev/auth",
+	}, {
+		op:   Ops{Registry: "localhost:5000", Repository: "test/app"},
+		repo: "localhost:5000/test/app",
+	}, {
+		op: Ops{
+			Registry:     "ghcr.io",
+			Repository:   "lesiw/app",
+			RegistryUser: "bot",
+			RegistryAuth: "ghcr.io/token",
+		},
+		repo: "ghcr.io/lesiw/app",
+		user: "bot",
+		auth: "ghcr.io/token",
+	}, {
+		op:   Ops{Registry: "docker.io", Repository: "lesiw/app"},
+		repo: "registry-1.docker.io/lesiw/app",
+	}, {
+		op:   Ops{Registry: "docker.io"},
+		repo: "registry-1.docker.io/library/app",
+	}}
+	for _, tt := range tests {
+		if got := tt.op.repository(); got != tt.repo {
+			t.Errorf("repository() = %q, want %q", got, tt.repo)
+		}
+		user, auth := tt.op.registryAuth()
+		if user != tt.user || auth != tt.auth {
+			t.Errorf("registryAuth() = %q, %q, want %q, %q",
+				user, auth, tt.user, tt.auth)
+		}
+	}
+}
+
+func TestTag(t *testing.T) {
+	swap(t, &goapp.Versionfile, "version.txt")
+	m := new(mock.Machine)
+	sh := command.Shell(m, "git")
+	swap(t, &golang.Build, sh)
+	swap(t, &golang.Local, sh)
+	ctx := context.Background()
+	m.Return(strings.NewReader("0123456789ab\n"),
+		"git", "rev-parse", "--short=12", "HEAD")
+	if err := sh.WriteFile(ctx, "version.txt",
+		[]byte("v1.2.0\n")); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		scheme TagScheme
+		want   string
+	}{
+		{TagGitSHA, "0123456789ab"},
+		{TagVersion, "v1.2.0"},
+	}
+	for _, tt := range tests {
+		got, err := Ops{Tag: tt.scheme}.tag(ctx)
+		if err != nil {
+			t.Errorf("tag(%s) err: %v", tt.scheme, err)
+		} else if got != tt.want {
+			t.Errorf("tag(%s) = %q, want %q", tt.scheme, got, tt.want)
+		}
+	}
+
+	if _, err := (Ops{Tag: "latest"}).tag(ctx); err == nil {
+		t.Error("tag() should fail on an unknown scheme")
+	}
+}
+
+func TestLinuxTargets(t *testing.T) {
+	swap(t, &goapp.Targets, []golang.Target{
+		{Goos: "linux", Goarch: "amd64"},
+		{Goos: "darwin", Goarch: "arm64"},
+		{Goos: "linux", Goarch: "arm"},
+	})
+
+	var got []string
+	for _, t := range linuxTargets() {
+		got = append(got, platform(t).String())
+	}
+
+	want := []string{"linux/amd64", "linux/arm/v7"}
+	if !slices.Equal(got, want) {
+		t.Errorf("platforms = %v, want %v", got, want)
+	}
+}
+
+func TestLayerFiles(t *testing.T) {
+	swap(t, &goapp.Name, "app")
+	sh := command.Shell(new(mock.Machine))
+	ctx := context.Background()
+	for name, data := range map[string]string{
+		"out/app-linux-aarch64": "bin",
+		"config/app.toml":       "port = 8080",
+		"static/css/style.css":  "body {}",
+		"static/js/app.js":      "main()",
+	} {
+		if err := sh.WriteFile(ctx, name, []byte(data)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	op := Ops{Image: Image{
+		Workdir: "/srv",
+		Files: map[string]string{
+			"static":          "/srv/static",
+			"config/app.toml": "/etc/app.toml",
+		},
+	}}
+
+	files, err := op.layerFiles(ctx, sh,
+		golang.Target{Goos: "linux", Goarch: "arm64"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := make(map[string]string)
+	for _, f := range files {
+		got[f.name] = string(f.data)
+	}
+	want := map[string]string{
+		"app":                      "bin",
+		"srv":                      "",
+		"etc/app.toml":             "port = 8080",
+		"srv/static":               "",
+		"srv/static/css":           "",
+		"srv/static/css/style.css": "body {}",
+		"srv/static/js":            "",
+		"srv/static/js/app.js":     "main()",
+	}
+	if !maps.Equal(got, want) {
+		t.Errorf("layerFiles() = %v, want %v", got, want)
+	}
+}
+
+// seedBase pushes a base image for linux/amd64 and linux/arm64
+// to repo:tag in f.
+func seedBase(t *testing.T, f *fakeRegistry, repo, tag string) {
+	t.Helper()
+	layer, diffID, err := newLayer([]layerFile{{
+		name: "etc/ssl/certs/ca-certificates.crt", mode: 0644,
+		data: []byte("certs"),
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.blobs[repo+"@"+layer.desc.Digest] = layer.data
+	idx := ociIndex{SchemaVersion: 2, MediaType: mediaTypeIndex}
+	for _, arch := range []string{"amd64", "arm64"} {
+		config, err := jsonBlob(mediaTypeConfig, imageConfig{
+			Architecture: arch,
+			OS:           "linux",
+			Config: containerConfig{
+				User:   "65532",
+				Env:    []string{"PATH=/bin"},
+				Cmd:    []string{"/bin/sh"},
+				Labels: map[string]string{"base": "yes"},
+			},
+			RootFS:  rootFS{Type: "layers", DiffIDs: []string{diffID}},
+			History: []layerHistory{{CreatedBy: "base"}},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.blobs[repo+"@"+config.desc.Digest] = config.data
+		m, err := jsonBlob(dockerManifest, ociManifest{
+			SchemaVersion: 2,
+			MediaType:     dockerManifest,
+			Config:        config.desc,
+			Layers: []descriptor{{
+				MediaType: dockerLayer,
+				Digest:    layer.desc.Digest,
+				Size:      layer.desc.Size,
+			}},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.putManifest(repo, m.desc.Digest, m)
+		d := m.desc
+		d.Platform = &ociPlatform{OS: "linux", Architecture: arch}
+		idx.Manifests = append(idx.Manifests, d)
+	}
+	b, err := jsonBlob(dockerManifestList, idx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.putManifest(repo, tag, b)
+}
+
+func TestCreateImage(t *testing.T) {
+	swap(t, &goapp.Name, "app")
+	swap(t, &goapp.Targets, []golang.Target{
+		{Goos: "linux", Goarch: "amd64"},
+		{Goos: "linux", Goarch: "arm64"},
+		{Goos: "darwin", Goarch: "arm64"},
+	})
+	m := new(mock.Machine)
+	gitsh := command.Shell(m, "git")
+	swap(t, &golang.Build, gitsh)
+	swap(t, &golang.Local, gitsh)
+	m.Return(strings.NewReader("0123456789ab\n"),
+		"git", "rev-parse", "--short=12", "HEAD")
+	m.Return(strings.NewReader("0123456789abcdef\n"),
+		"git", "rev-parse", "HEAD")
+	ctx := context.Background()
+	sh := command.Shell(new(mock.Machine))
+	for _, name := range []string{
+		"out/app-linux-x86_64", "out/app-linux-aarch64",
+	} {
+		if err := sh.WriteFile(ctx, name, []byte(name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	f := newFakeRegistry(t)
+	seedBase(t, f, "distroless/static", "nonroot")
+	op := Ops{
+		Port:       8080,
+		Registry:   f.host,
+		Repository: "apps/app",
+		Tag:        TagGitSHA,
+		Image: Image{
+			Base: f.host + "/distroless/static:nonroot",
+			Args: []string{"serve"},
+		},
+	}
+
+	ref, err := op.createImage(ctx, sh)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	index, ok := f.manifest("apps/app", "0123456789ab")
+	if !ok {
+		t.Fatal("index was not pushed as apps/app:0123456789ab")
+	}
+	if want := f.host + "/apps/app@" + index.desc.Digest; ref != want {
+		t.Errorf("createImage() = %q, want %q", ref, want)
+	}
+	var idx ociIndex
+	if err := json.Unmarshal(index.data, &idx); err != nil {
+		t.Fatal(err)
+	}
+	if len(idx.Manifests) != 2 {
+		t.Fatalf("index has %d manifests, want 2", len(idx.Manifests))
+	}
+	arm64 := idx.Manifests[1]
+	if p := arm64.Platform; p == nil || p.Architecture != "arm64" {
+		t.Fatalf("second manifest platform = %v, want arm64", p)
+	}
+	mb, ok := f.manifest("apps/app", arm64.Digest)
+	if !ok {
+		t.Fatal("arm64 manifest was not pushed")
+	}
+	var man ociManifest
+	if err := json.Unmarshal(mb.data, &man); err != nil {
+		t.Fatal(err)
+	}
+	if len(man.Layers) != 2 {
+		t.Fatalf("manifest has %d layers, want 2", len(man.Layers))
+	}
+	for _, l := range man.Layers {
+		if _, ok := f.blobs["apps/app@"+l.Digest]; !ok {
+			t.Errorf("layer %s was not pushed", l.Digest)
+		}
+	}
+	var cfg imageConfig
+	if err := json.Unmarshal(
+		f.blobs["apps/app@"+man.Config.Digest], &cfg); err != nil {
+		t.Fatal(err)
+	}
+	c := cfg.Config
+	if c.User != "65532:65532" ||
+		!slices.Equal(c.Entrypoint, []string{"/app"}) ||
+		!slices.Equal(c.Cmd, []string{"serve"}) {
+		t.Errorf("config = %+v, want nonroot /app serve", c)
+	}
+	if _, ok := c.ExposedPorts["8080/tcp"]; !ok {
+		t.Errorf("config does not expose 8080/tcp: %v", c.ExposedPorts)
+	}
+	if c.Labels["base"] != "yes" ||
+		c.Labels["org.opencontainers.image.revision"] != "0123456789abcdef" {
+		t.Errorf("config labels = %v, want base and revision", c.Labels)
+	}
+	if !slices.Equal(c.Env, []string{"PATH=/bin"}) {
+		t.Errorf("config env = %v, want the base's", c.Env)
+	}
+	if len(cfg.RootFS.DiffIDs) != 2 || len(cfg.History) != 2 {
+		t.Errorf("config has %d diff IDs and %d history entries, want 2",
+			len(cfg.RootFS.DiffIDs), len(cfg.History))
+	}
+}
+
+func TestImagesDefaultTargets(t *testing.T) {
+	swap(t, &goapp.Name, "app")
+	gitsh := command.Shell(new(mock.Machine), "git")
+	swap(t, &golang.Build, gitsh)
+	swap(t, &golang.Local, gitsh)
+	ctx := context.Background()
+	sh := command.Shell(new(mock.Machine))
+	for _, tg := range linuxTargets() {
+		name := "out/app-" + tg.Unames() + "-" + tg.Unamer()
+		if err := sh.WriteFile(ctx, name, []byte(name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	f := newFakeRegistry(t)
+	seedBase(t, f, "distroless/static", "nonroot")
+	op := Ops{Image: Image{Base: f.host + "/distroless/static:nonroot"}}
+
+	imgs, err := op.images(ctx, sh, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	for _, img := range imgs {
+		got = append(got, img.platform.String())
+	}
+	want := []string{"linux/amd64", "linux/arm64"}
+	if !slices.Equal(got, want) {
+		t.Errorf("image platforms = %v, want %v", got, want)
+	}
+}
+
+func TestImageLabels(t *testing.T) {
+	swap(t, &goapp.Name, "app")
+	swap(t, &goapp.Versionfile, "version.txt")
+	m := new(mock.Machine)
+	sh := command.Shell(m, "git")
+	swap(t, &golang.Build, sh)
+	swap(t, &golang.Local, sh)
+	m.Return(strings.NewReader("https://github.com/lesiw/app\n"),
+		"git", "remote", "get-url", "origin")
+	m.Return(strings.NewReader("abc123\n"), "git", "rev-parse", "HEAD")
+	op := Ops{Image: Image{Labels: map[string]string{
+		"org.opencontainers.image.title": "App",
+	}}}
+
+	got := op.imageLabels(context.Background())
+
+	want := map[string]string{
+		"org.opencontainers.image.title":    "App",
+		"org.opencontainers.image.source":   "https://github.com/lesiw/app",
+		"org.opencontainers.image.revision": "abc123",
+	}
+	if !maps.Equal(got, want) {
+		t.Errorf("imageLabels() = %v, want %v", got, want)
+	}
+}
+
+func TestImageKeepsBaseConfig(t *testing.T) {
+	var base imageConfig
+	err := json.Unmarshal([]byte(`{
+		"created": "2024-01-02T03:04:05Z",
+		"architecture": "amd64",
+		"os": "linux",
+		"os.version": "10.0",
+		"config": {
+			"Cmd": ["sh"],
+			"StopSignal": "SIGQUIT",
+			"Volumes": {"/data": {}},
+			"Healthcheck": {"Test": ["CMD", "true"]}
+		},
+		"rootfs": {"type": "layers", "diff_ids": []},
+		"history": [{"created": "2024-01-02T03:04:05Z", "created_by": "x"}]
+	}`), &base)
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := []layerFile{{name: "app", mode: 0755, data: []byte("bin")}}
+
+	img, err := Ops{}.image(ociManifest{}, base,
+		ociPlatform{OS: "linux", Architecture: "amd64"}, files, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got struct {
+		Created   string `json:"created"`
+		OSVersion string `json:"os.version"`
+		Config    struct {
+			Cmd         []string
+			StopSignal  string
+			Volumes     map[string]struct{}
+			Healthcheck struct{ Test []string }
+		} `json:"config"`
+		History []map[string]any `json:"history"`
+	}
+	if err := json.Unmarshal(img.config.data, &got); err != nil {
+		t.Fatal(err)
+	}
+	c := got.Config
+	if got.Created == "" || got.OSVersion != "10.0" ||
+		c.StopSignal != "SIGQUIT" || len(c.Volumes) != 1 ||
+		len(c.Healthcheck.Test) != 2 || got.History[0]["created"] == nil {
+		t.Errorf("config lost base fields:\n%s", img.config.data)
+	}
+	if c.Cmd != nil {
+		t.Errorf("config Cmd = %q, want none", c.Cmd)
+	}
+}
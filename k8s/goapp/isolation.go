@@ -0,0 +1,150 @@
+package goapp
+
+import (
+	"context"
+	"fmt"
+
+	"labs.lesiw.io/ops/golang"
+	"lesiw.io/command"
+	"lesiw.io/command/sub"
+)
+
+// defaultIngressNamespace is where k3s runs its Traefik ingress controller.
+const defaultIngressNamespace = "kube-system"
+
+// smokeTestLabel marks the pods that run smoke tests,
+// so that the network policy lets them in.
+const smokeTestLabel = "lesiw.io/smoke-test"
+
+// isolation returns the objects that limit the application's namespace
+// and restrict who can reach its pods. Namespace limits need a namespace
+// of the application's own, so that they do not apply to others' pods.
+func (op Ops) isolation(labels map[string]string) ([]any, error) {
+	var docs []any
+	limits := len(op.Quota) > 0 ||
+		len(op.DefaultRequests) > 0 || len(op.DefaultLimits) > 0
+	if limits && op.cluster.namespace == "" {
+		return nil, fmt.Errorf("Quota, DefaultRequests and DefaultLimits " +
+			"need a Namespace of the application's own")
+	}
+	if len(op.Quota) > 0 {
+		docs = append(docs, object[quotaSpec]{
+			APIVersion: "v1",
+			Kind:       "ResourceQuota",
+			Metadata:   objectMeta{Name: op.name()},
+			Spec:       quotaSpec{Hard: op.Quota},
+		})
+	}
+	if len(op.DefaultRequests) > 0 || len(op.DefaultLimits) > 0 {
+		docs = append(docs, object[limitRangeSpec]{
+			APIVersion: "v1",
+			Kind:       "LimitRange",
+			Metadata:   objectMeta{Name: op.name()},
+			Spec: limitRangeSpec{Limits: []limitRangeItem{{
+				Type:           "Container",
+				DefaultRequest: op.DefaultRequests,
+				Default:        op.DefaultLimits,
+			}}},
+		})
+	}
+	if op.IsolateNetwork {
+		docs = append(docs, op.networkPolicy(labels))
+	}
+	return docs, nil
+}
+
+// networkPolicy denies all traffic into the application's pods except
+// from the ingress controller, the Postgres cluster and smoke tests.
+func (op Ops) networkPolicy(labels map[string]string) any {
+	ingressNS := op.IngressNamespace
+	if ingressNS == "" {
+		ingressNS = defaultIngressNamespace
+	}
+	from := []networkPolicyPeer{
+		{NamespaceSelector: namespaceSelector(ingressNS)},
+		{PodSelector: &labelSelector{
+			MatchLabels: map[string]string{smokeTestLabel: op.name()},
+		}},
+	}
+	if op.Postgres {
+		pg := &labelSelector{
+			MatchLabels: map[string]string{"cnpg.io/cluster": "postgres"},
+		}
+		if op.PostgresNamespace == "" {
+			from = append(from, networkPolicyPeer{PodSelector: pg})
+		} else {
+			from = append(from, networkPolicyPeer{
+				NamespaceSelector: namespaceSelector(op.PostgresNamespace),
+				PodSelector:       pg,
+			})
+		}
+	}
+	return object[networkPolicySpec]{
+		APIVersion: "networking.k8s.io/v1",
+		Kind:       "NetworkPolicy",
+		Metadata:   objectMeta{Name: op.name()},
+		Spec: networkPolicySpec{
+			PodSelector: labelSelector{MatchLabels: labels},
+			PolicyTypes: []string{"Ingress"},
+			Ingress:     []networkPolicyRule{{From: from}},
+		},
+	}
+}
+
+func namespaceSelector(ns string) *labelSelector {
+	return &labelSelector{MatchLabels: map[string]string{
+		"kubernetes.io/metadata.name": ns,
+	}}
+}
+
+// postgres returns a machine that runs commands in the primary instance
+// of the Postgres cluster. flags are added to kubectl exec.
+func (op Ops) postgres(
+	kubectl command.Machine, flags ...string,
+) command.Machine {
+	args := []string{"exec"}
+	if op.PostgresNamespace != "" {
+		args = append(args, "-n", op.PostgresNamespace)
+	}
+	args = append(args, flags...)
+	args = append(args, "postgres-1", "-c", "postgres", "--")
+	return sub.Machine(kubectl, args...)
+}
+
+// postgresHost returns the host name of the Postgres cluster's
+// read-write service.
+func (op Ops) postgresHost() string {
+	if op.PostgresNamespace == "" {
+		return "postgres-rw"
+	}
+	return "postgres-rw." + op.PostgresNamespace + ".svc"
+}
+
+// createNamespace creates the application's namespace if it is missing,
+// and labels it as owned by the application. Namespaces that already
+// exist may be shared, so they are left as they are.
+func (op Ops) createNamespace(ctx context.Context) error {
+	ns := op.cluster.namespace
+	if ns == "" {
+		return nil
+	}
+	kubectl, err := op.kubectl()
+	if err != nil {
+		return err
+	}
+	if command.Do(ctx, kubectl, "get", "namespace", ns) == nil {
+		return nil
+	}
+	err = golang.Mutate(ctx, kubectl, "create", "namespace", ns)
+	if err != nil {
+		return fmt.Errorf("could not create namespace %s: %w", ns, err)
+	}
+	err = golang.Mutate(ctx, kubectl,
+		"label", "namespace", ns, "--overwrite",
+		"app.kubernetes.io/managed-by=lesiw-ops",
+		"app.kubernetes.io/instance="+op.name())
+	if err != nil {
+		return fmt.Errorf("could not label namespace %s: %w", ns, err)
+	}
+	return nil
+}
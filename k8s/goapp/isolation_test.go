@@ -0,0 +1,129 @@
+package goapp
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	"lesiw.io/command"
+	"lesiw.io/command/mock"
+
+	"labs.lesiw.io/ops/goapp"
+)
+
+func TestIsolation(t *testing.T) {
+	swap(t, &goapp.Name, "app")
+	op := Ops{
+		Postgres:          true,
+		Port:              8080,
+		Quota:             map[string]string{"requests.cpu": "2"},
+		DefaultLimits:     map[string]string{"memory": "256Mi"},
+		IsolateNetwork:    true,
+		PostgresNamespace: "db",
+		cluster:           cluster{namespace: "app"},
+	}
+
+	res, err := splitManifests(mustManifests(t, op))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, k := range []resourceKey{
+		{"ResourceQuota", "app"},
+		{"LimitRange", "app"},
+		{"NetworkPolicy", "app"},
+	} {
+		if _, ok := res[k]; !ok {
+			t.Errorf("chart has no %s", k)
+		}
+	}
+	db := res[resourceKey{"Database", "app"}]
+	if !strings.Contains(db, "namespace: db") {
+		t.Errorf("Database is not in the db namespace:\n%s", db)
+	}
+	if !strings.Contains(mustManifests(t, op), "postgres-rw.db.svc") {
+		t.Errorf("PGHOST does not point at the db namespace")
+	}
+
+	np := op.networkPolicy(map[string]string{"app": "app"})
+	from := np.(object[networkPolicySpec]).Spec.Ingress[0].From
+	want := []networkPolicyPeer{
+		{NamespaceSelector: namespaceSelector("kube-system")},
+		{PodSelector: &labelSelector{
+			MatchLabels: map[string]string{smokeTestLabel: "app"},
+		}},
+		{
+			NamespaceSelector: namespaceSelector("db"),
+			PodSelector: &labelSelector{
+				MatchLabels: map[string]string{"cnpg.io/cluster": "postgres"},
+			},
+		},
+	}
+	if !reflect.DeepEqual(from, want) {
+		t.Errorf("network policy peers = %+v, want %+v", from, want)
+	}
+}
+
+func TestIsolationDefaults(t *testing.T) {
+	swap(t, &goapp.Name, "app")
+
+	res, err := splitManifests(mustManifests(t, Ops{Port: 8080}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for k := range res {
+		switch k.kind {
+		case "ResourceQuota", "LimitRange", "NetworkPolicy":
+			t.Errorf("chart has %s without isolation settings", k)
+		}
+	}
+}
+
+func TestIsolationSharedNamespace(t *testing.T) {
+	swap(t, &goapp.Name, "app")
+	op := Ops{Quota: map[string]string{"requests.cpu": "2"}}
+
+	if _, err := op.manifests("registry.test/app:1"); err == nil {
+		t.Error("manifests() should fail with a Quota and no Namespace")
+	}
+}
+
+func TestCreateNamespace(t *testing.T) {
+	swap(t, &goapp.Name, "app")
+	m := setupCluster(t)
+	op := Ops{cluster: cluster{namespace: "shared"}}
+
+	if err := op.createNamespace(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if calls := mock.Calls(m, "kubectl", "label"); len(calls) > 0 {
+		t.Errorf("labeled an existing namespace: %v", calls)
+	}
+
+	m.Return(command.Fail(&command.Error{Code: 1}),
+		"kubectl", "get", "namespace")
+	if err := op.createNamespace(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if calls := mock.Calls(m, "kubectl", "label"); len(calls) != 1 {
+		t.Errorf("got %d labels of a new namespace, want 1", len(calls))
+	}
+}
+
+func TestPostgres(t *testing.T) {
+	ctx := context.Background()
+	m := new(mock.Machine)
+	op := Ops{PostgresNamespace: "db"}
+
+	if err := command.Do(ctx, op.postgres(m, "-i"), "psql"); err != nil {
+		t.Fatal(err)
+	}
+
+	got := mock.Calls(m, "exec")
+	want := "exec -n db -i postgres-1 -c postgres -- psql"
+	if len(got) != 1 || strings.Join(got[0].Args, " ") != want {
+		t.Errorf("calls = %v, want %q", got, want)
+	}
+}
@@ -0,0 +1,333 @@
+package goapp
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"go.yaml.in/yaml/v3"
+)
+
+// The types below model only the parts of the Kubernetes API
+// that the generated charts use.
+
+type object[S any] struct {
+	APIVersion string     `yaml:"apiVersion"`
+	Kind       string     `yaml:"kind"`
+	Metadata   objectMeta `yaml:"metadata"`
+	Spec       S          `yaml:"spec"`
+}
+
+type objectMeta struct {
+	Name        string            `yaml:"name,omitempty"`
+	Namespace   string            `yaml:"namespace,omitempty"`
+	Labels      map[string]string `yaml:"labels,omitempty"`
+	Annotations map[string]string `yaml:"annotations,omitempty"`
+}
+
+type secret struct {
+	APIVersion string            `yaml:"apiVersion"`
+	Kind       string            `yaml:"kind"`
+	Metadata   objectMeta        `yaml:"metadata"`
+	Type       string            `yaml:"type"`
+	StringData map[string]string `yaml:"stringData"`
+}
+
+type configMap struct {
+	APIVersion string            `yaml:"apiVersion"`
+	Kind       string            `yaml:"kind"`
+	Metadata   objectMeta        `yaml:"metadata"`
+	Data       map[string]string `yaml:"data"`
+}
+
+// workloadSpec is the spec of a StatefulSet or a Deployment.
+type workloadSpec struct {
+	Replicas        int                 `yaml:"replicas"`
+	MinReadySeconds int                 `yaml:"minReadySeconds,omitempty"`
+	Strategy        *deploymentStrategy `yaml:"strategy,omitempty"`
+	Selector        labelSelector       `yaml:"selector"`
+	Template        podTemplate         `yaml:"template"`
+}
+
+type deploymentStrategy struct {
+	Type          string         `yaml:"type"`
+	RollingUpdate *rollingUpdate `yaml:"rollingUpdate,omitempty"`
+}
+
+type rollingUpdate struct {
+	MaxSurge       int `yaml:"maxSurge"`
+	MaxUnavailable int `yaml:"maxUnavailable"`
+}
+
+type labelSelector struct {
+	MatchLabels map[string]string `yaml:"matchLabels"`
+}
+
+type podTemplate struct {
+	Metadata objectMeta `yaml:"metadata"`
+	Spec     podSpec    `yaml:"spec"`
+}
+
+type podSpec struct {
+	ServiceAccountName string      `yaml:"serviceAccountName,omitempty"`
+	ImagePullSecrets   []reference `yaml:"imagePullSecrets,omitempty"`
+	Containers         []container `yaml:"containers"`
+}
+
+type reference struct {
+	Name string `yaml:"name"`
+}
+
+type container struct {
+	Name            string    `yaml:"name"`
+	Image           string    `yaml:"image"`
+	ImagePullPolicy string    `yaml:"imagePullPolicy,omitempty"`
+	Resources       resources `yaml:"resources"`
+	Env             []envVar  `yaml:"env,omitempty"`
+	LivenessProbe   *probe    `yaml:"livenessProbe,omitempty"`
+	ReadinessProbe  *probe    `yaml:"readinessProbe,omitempty"`
+	StartupProbe    *probe    `yaml:"startupProbe,omitempty"`
+}
+
+type probe struct {
+	HTTPGet             *httpGetAction   `yaml:"httpGet,omitempty"`
+	TCPSocket           *tcpSocketAction `yaml:"tcpSocket,omitempty"`
+	Exec                *execAction      `yaml:"exec,omitempty"`
+	InitialDelaySeconds int              `yaml:"initialDelaySeconds,omitempty"`
+	PeriodSeconds       int              `yaml:"periodSeconds,omitempty"`
+	TimeoutSeconds      int              `yaml:"timeoutSeconds,omitempty"`
+	FailureThreshold    int              `yaml:"failureThreshold,omitempty"`
+}
+
+type httpGetAction struct {
+	Path string `yaml:"path"`
+	Port int    `yaml:"port"`
+}
+
+type tcpSocketAction struct {
+	Port int `yaml:"port"`
+}
+
+type execAction struct {
+	Command []string `yaml:"command"`
+}
+
+type resources struct {
+	Requests map[string]string `yaml:"requests,omitempty"`
+	Limits   map[string]string `yaml:"limits,omitempty"`
+}
+
+type envVar struct {
+	Name      string        `yaml:"name"`
+	Value     string        `yaml:"value,omitempty"`
+	ValueFrom *envVarSource `yaml:"valueFrom,omitempty"`
+}
+
+type envVarSource struct {
+	SecretKeyRef *secretKeySelector `yaml:"secretKeyRef,omitempty"`
+}
+
+type secretKeySelector struct {
+	Name string `yaml:"name"`
+	Key  string `yaml:"key"`
+}
+
+type hpaSpec struct {
+	ScaleTargetRef scaleTargetRef `yaml:"scaleTargetRef"`
+	MinReplicas    int            `yaml:"minReplicas"`
+	MaxReplicas    int            `yaml:"maxReplicas"`
+	Metrics        []metricSpec   `yaml:"metrics,omitempty"`
+}
+
+type scaleTargetRef struct {
+	APIVersion string `yaml:"apiVersion"`
+	Kind       string `yaml:"kind"`
+	Name       string `yaml:"name"`
+}
+
+type metricSpec struct {
+	Type     string          `yaml:"type"`
+	Resource *resourceMetric `yaml:"resource,omitempty"`
+	Pods     *podsMetric     `yaml:"pods,omitempty"`
+}
+
+type resourceMetric struct {
+	Name   string       `yaml:"name"`
+	Target metricTarget `yaml:"target"`
+}
+
+type podsMetric struct {
+	Metric metricIdentifier `yaml:"metric"`
+	Target metricTarget     `yaml:"target"`
+}
+
+type metricIdentifier struct {
+	Name string `yaml:"name"`
+}
+
+type metricTarget struct {
+	Type               string `yaml:"type"`
+	AverageUtilization int    `yaml:"averageUtilization,omitempty"`
+	AverageValue       string `yaml:"averageValue,omitempty"`
+}
+
+type pdbSpec struct {
+	MinAvailable int           `yaml:"minAvailable"`
+	Selector     labelSelector `yaml:"selector"`
+}
+
+type serviceSpec struct {
+	Ports    []servicePort     `yaml:"ports"`
+	Selector map[string]string `yaml:"selector"`
+}
+
+type servicePort struct {
+	Port       int    `yaml:"port"`
+	Protocol   string `yaml:"protocol"`
+	TargetPort int    `yaml:"targetPort"`
+}
+
+type databaseSpec struct {
+	DatabaseReclaimPolicy string    `yaml:"databaseReclaimPolicy"`
+	Name                  string    `yaml:"name"`
+	Owner                 string    `yaml:"owner"`
+	Cluster               reference `yaml:"cluster"`
+}
+
+type quotaSpec struct {
+	Hard map[string]string `yaml:"hard"`
+}
+
+type limitRangeSpec struct {
+	Limits []limitRangeItem `yaml:"limits"`
+}
+
+type limitRangeItem struct {
+	Type           string            `yaml:"type"`
+	Default        map[string]string `yaml:"default,omitempty"`
+	DefaultRequest map[string]string `yaml:"defaultRequest,omitempty"`
+}
+
+type networkPolicySpec struct {
+	PodSelector labelSelector       `yaml:"podSelector"`
+	PolicyTypes []string            `yaml:"policyTypes"`
+	Ingress     []networkPolicyRule `yaml:"ingress"`
+}
+
+type networkPolicyRule struct {
+	From []networkPolicyPeer `yaml:"from"`
+}
+
+type networkPolicyPeer struct {
+	NamespaceSelector *labelSelector `yaml:"namespaceSelector,omitempty"`
+	PodSelector       *labelSelector `yaml:"podSelector,omitempty"`
+}
+
+type certificateSpec struct {
+	SecretName string    `yaml:"secretName"`
+	DNSNames   []string  `yaml:"dnsNames"`
+	IssuerRef  issuerRef `yaml:"issuerRef"`
+}
+
+type issuerRef struct {
+	Name string `yaml:"name"`
+	Kind string `yaml:"kind"`
+}
+
+type ingressSpec struct {
+	TLS   []ingressTLS  `yaml:"tls,omitempty"`
+	Rules []ingressRule `yaml:"rules"`
+}
+
+type ingressTLS struct {
+	Hosts      []string `yaml:"hosts"`
+	SecretName string   `yaml:"secretName"`
+}
+
+type ingressRule struct {
+	Host string          `yaml:"host"`
+	HTTP httpIngressRule `yaml:"http"`
+}
+
+type httpIngressRule struct {
+	Paths []ingressPath `yaml:"paths"`
+}
+
+type ingressPath struct {
+	Backend  ingressBackend `yaml:"backend"`
+	Path     string         `yaml:"path"`
+	PathType string         `yaml:"pathType"`
+}
+
+type ingressBackend struct {
+	Service serviceBackend `yaml:"service"`
+}
+
+type serviceBackend struct {
+	Name string      `yaml:"name"`
+	Port backendPort `yaml:"port"`
+}
+
+type backendPort struct {
+	Number int `yaml:"number"`
+}
+
+type chartMeta struct {
+	APIVersion string `yaml:"apiVersion"`
+	Name       string `yaml:"name"`
+	Type       string `yaml:"type"`
+	Version    string `yaml:"version"`
+}
+
+// marshalDocs encodes docs as a stream of YAML documents.
+func marshalDocs(docs ...any) (string, error) {
+	var buf strings.Builder
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	for _, doc := range docs {
+		if err := enc.Encode(doc); err != nil {
+			return "", err
+		}
+	}
+	if err := enc.Close(); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// parseDocs decodes a stream of YAML documents,
+// each of which must be a Kubernetes object. Empty documents are skipped.
+func parseDocs(s string) ([]any, error) {
+	var docs []any
+	dec := yaml.NewDecoder(strings.NewReader(s))
+	for i := 1; ; i++ {
+		doc := new(yaml.Node)
+		err := dec.Decode(doc)
+		if errors.Is(err, io.EOF) {
+			return docs, nil
+		} else if err != nil {
+			return nil, fmt.Errorf("document %d: %w", i, err)
+		}
+		if len(doc.Content) == 0 || doc.Content[0].Tag == "!!null" {
+			continue
+		}
+		var obj struct {
+			APIVersion string `yaml:"apiVersion"`
+			Kind       string `yaml:"kind"`
+		}
+		if err := doc.Decode(&obj); err != nil {
+			return nil, fmt.Errorf("document %d: %w", i, err)
+		}
+		if obj.APIVersion == "" || obj.Kind == "" {
+			return nil, fmt.Errorf(
+				"document %d: missing apiVersion or kind", i)
+		}
+		docs = append(docs, doc)
+	}
+}
+
+// escapeTemplate makes s render as itself when used as a Helm template.
+func escapeTemplate(s string) string {
+	return strings.ReplaceAll(s, "{{", `{{"{{"}}`)
+}
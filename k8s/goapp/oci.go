@@ -0,0 +1,456 @@
+package goapp
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"maps"
+	"path"
+	"reflect"
+	"slices"
+	"strings"
+	"time"
+
+	"lesiw.io/command"
+)
+
+const (
+	mediaTypeIndex    = "application/vnd.oci.image.index.v1+json"
+	mediaTypeManifest = "application/vnd.oci.image.manifest.v1+json"
+	mediaTypeConfig   = "application/vnd.oci.image.config.v1+json"
+	mediaTypeLayer    = "application/vnd.oci.image.layer.v1.tar+gzip"
+
+	dockerManifestList = "application/vnd.docker.distribution." +
+		"manifest.list.v2+json"
+	dockerManifest = "application/vnd.docker.distribution.manifest.v2+json"
+	dockerLayer    = "application/vnd.docker.image.rootfs.diff.tar.gzip"
+)
+
+// A descriptor points to a blob, as in the OCI image spec.
+type descriptor struct {
+	MediaType   string            `json:"mediaType"`
+	Digest      string            `json:"digest"`
+	Size        int64             `json:"size"`
+	Platform    *ociPlatform      `json:"platform,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+type ociPlatform struct {
+	Architecture string `json:"architecture"`
+	OS           string `json:"os"`
+	Variant      string `json:"variant,omitempty"`
+}
+
+type ociIndex struct {
+	SchemaVersion int          `json:"schemaVersion"`
+	MediaType     string       `json:"mediaType"`
+	Manifests     []descriptor `json:"manifests"`
+}
+
+type ociManifest struct {
+	SchemaVersion int          `json:"schemaVersion"`
+	MediaType     string       `json:"mediaType"`
+	Config        descriptor   `json:"config"`
+	Layers        []descriptor `json:"layers"`
+}
+
+// An imageConfig is an image configuration. Fields it does not model,
+// such as created or os.version, are kept from the base image.
+type imageConfig struct {
+	Architecture string          `json:"architecture"`
+	OS           string          `json:"os"`
+	Variant      string          `json:"variant,omitempty"`
+	Config       containerConfig `json:"config"`
+	RootFS       rootFS          `json:"rootfs"`
+	History      []layerHistory  `json:"history,omitempty"`
+
+	extra map[string]json.RawMessage
+}
+
+func (c imageConfig) MarshalJSON() ([]byte, error) {
+	type plain imageConfig
+	return marshalExtra(plain(c), c.extra)
+}
+
+func (c *imageConfig) UnmarshalJSON(data []byte) error {
+	type plain imageConfig
+	return unmarshalExtra(data, (*plain)(c), &c.extra)
+}
+
+// A containerConfig is the config of an imageConfig. Like imageConfig,
+// it keeps the fields it does not model, such as StopSignal or Volumes.
+type containerConfig struct {
+	User         string              `json:"User,omitempty"`
+	ExposedPorts map[string]struct{} `json:"ExposedPorts,omitempty"`
+	Env          []string            `json:"Env,omitempty"`
+	Entrypoint   []string            `json:"Entrypoint,omitempty"`
+	Cmd          []string            `json:"Cmd,omitempty"`
+	WorkingDir   string              `json:"WorkingDir,omitempty"`
+	Labels       map[string]string   `json:"Labels,omitempty"`
+
+	extra map[string]json.RawMessage
+}
+
+func (c containerConfig) MarshalJSON() ([]byte, error) {
+	type plain containerConfig
+	return marshalExtra(plain(c), c.extra)
+}
+
+func (c *containerConfig) UnmarshalJSON(data []byte) error {
+	type plain containerConfig
+	return unmarshalExtra(data, (*plain)(c), &c.extra)
+}
+
+type rootFS struct {
+	Type    string   `json:"type"`
+	DiffIDs []string `json:"diff_ids"`
+}
+
+type layerHistory struct {
+	CreatedBy  string `json:"created_by,omitempty"`
+	EmptyLayer bool   `json:"empty_layer,omitempty"`
+
+	extra map[string]json.RawMessage
+}
+
+func (h layerHistory) MarshalJSON() ([]byte, error) {
+	type plain layerHistory
+	return marshalExtra(plain(h), h.extra)
+}
+
+func (h *layerHistory) UnmarshalJSON(data []byte) error {
+	type plain layerHistory
+	return unmarshalExtra(data, (*plain)(h), &h.extra)
+}
+
+// unmarshalExtra decodes data into v, a pointer to a struct, and the
+// fields of data that v has no field for into extra.
+func unmarshalExtra(
+	data []byte, v any, extra *map[string]json.RawMessage,
+) error {
+	if err := json.Unmarshal(data, v); err != nil {
+		return err
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return err
+	}
+	for _, name := range jsonNames(reflect.TypeOf(v).Elem()) {
+		delete(fields, name)
+	}
+	*extra = fields
+	return nil
+}
+
+// marshalExtra encodes v, a struct, along with the fields in extra.
+func marshalExtra(v any, extra map[string]json.RawMessage) ([]byte, error) {
+	data, err := json.Marshal(v)
+	if err != nil || len(extra) == 0 {
+		return data, err
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+	for name, value := range extra {
+		if _, ok := fields[name]; !ok {
+			fields[name] = value
+		}
+	}
+	return json.Marshal(fields)
+}
+
+// jsonNames returns the JSON names of the fields of the struct type t.
+func jsonNames(t reflect.Type) []string {
+	var names []string
+	for i := range t.NumField() {
+		tag := t.Field(i).Tag.Get("json")
+		if name, _, _ := strings.Cut(tag, ","); name != "" && name != "-" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// A blob is content addressed by its digest.
+type blob struct {
+	desc descriptor
+	data []byte
+}
+
+func newBlob(mediaType string, data []byte) blob {
+	return blob{
+		desc: descriptor{
+			MediaType: mediaType,
+			Digest:    digestOf(data),
+			Size:      int64(len(data)),
+		},
+		data: data,
+	}
+}
+
+func jsonBlob(mediaType string, v any) (blob, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return blob{}, err
+	}
+	return newBlob(mediaType, data), nil
+}
+
+// An imageRef names an image in a registry.
+type imageRef struct {
+	host string // Registry host, such as ctr.lesiw.dev.
+	repo string // Repository, such as distroless/static.
+	ref  string // Tag or digest.
+}
+
+// parseImageRef parses an image name, such as alpine:3 or
+// gcr.io/distroless/static@sha256:..., following Docker's rules for
+// names without a registry host.
+func parseImageRef(s string) (imageRef, error) {
+	var r imageRef
+	name := s
+	if n, d, ok := strings.Cut(s, "@"); ok {
+		name, r.ref = n, d
+	} else if i := strings.LastIndex(s, ":"); i > strings.LastIndex(s, "/") {
+		name, r.ref = s[:i], s[i+1:]
+	} else {
+		r.ref = "latest"
+	}
+	host, repo, ok := strings.Cut(name, "/")
+	if ok && (strings.ContainsAny(host, ".:") || host == "localhost") {
+		r.host, r.repo = host, repo
+	} else {
+		r.host, r.repo = "docker.io", name
+	}
+	r.host, r.repo = dockerHub(r.host, r.repo)
+	if r.repo == "" || r.ref == "" || r.repo != strings.ToLower(r.repo) {
+		return imageRef{}, fmt.Errorf("bad image name %q", s)
+	}
+	return r, nil
+}
+
+// dockerHub returns the registry host and repository that docker uses
+// for repository on registryHost, which differ from them on Docker Hub:
+// docker.io/alpine is registry-1.docker.io/library/alpine.
+func dockerHub(registryHost, repository string) (host, repo string) {
+	if registryHost != "docker.io" && registryHost != "index.docker.io" {
+		return registryHost, repository
+	}
+	if !strings.Contains(repository, "/") {
+		repository = "library/" + repository
+	}
+	return "registry-1.docker.io", repository
+}
+
+// An ociImage is an image for one platform.
+type ociImage struct {
+	platform ociPlatform
+	manifest blob
+	config   blob
+	layers   []blob      // Layers added to the base image.
+	files    []layerFile // Files in layers.
+
+	base     []descriptor // Layers of the base image.
+	from     *registry    // Registry of the base image.
+	fromRepo string       // Repository of the base image.
+}
+
+// errNoPlatform means that an image index has no image for a platform.
+var errNoPlatform = errors.New("no image for")
+
+// baseImage returns the manifest and config of the image that ref names,
+// for platform p.
+func baseImage(
+	ctx context.Context, reg *registry, ref imageRef, p ociPlatform,
+) (ociManifest, imageConfig, error) {
+	var m ociManifest
+	var cfg imageConfig
+	b, err := reg.manifest(ctx, ref.repo, ref.ref)
+	if err != nil {
+		return m, cfg, err
+	}
+	if b.desc.MediaType == mediaTypeIndex ||
+		b.desc.MediaType == dockerManifestList {
+		var idx ociIndex
+		if err := json.Unmarshal(b.data, &idx); err != nil {
+			return m, cfg, fmt.Errorf("could not parse index: %w", err)
+		}
+		i := slices.IndexFunc(idx.Manifests, func(d descriptor) bool {
+			return d.Platform != nil && d.Platform.OS == p.OS &&
+				d.Platform.Architecture == p.Architecture &&
+				(p.Variant == "" || d.Platform.Variant == "" ||
+					d.Platform.Variant == p.Variant)
+		})
+		if i < 0 {
+			return m, cfg, fmt.Errorf("%s/%s has %w %s",
+				ref.host, ref.repo, errNoPlatform, p)
+		}
+		b, err = reg.manifest(ctx, ref.repo, idx.Manifests[i].Digest)
+		if err != nil {
+			return m, cfg, err
+		}
+	}
+	if err := json.Unmarshal(b.data, &m); err != nil {
+		return m, cfg, fmt.Errorf("could not parse manifest: %w", err)
+	}
+	data, err := reg.blob(ctx, ref.repo, m.Config.Digest)
+	if err != nil {
+		return m, cfg, err
+	}
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return m, cfg, fmt.Errorf("could not parse image config: %w", err)
+	}
+	for i, l := range m.Layers {
+		if l.MediaType == dockerLayer {
+			m.Layers[i].MediaType = mediaTypeLayer
+		}
+	}
+	return m, cfg, nil
+}
+
+// A layerFile is a file or directory in an image layer.
+type layerFile struct {
+	name string // Path in the image, without a leading slash.
+	mode fs.FileMode
+	data []byte
+}
+
+// newLayer returns a gzipped layer of files and its uncompressed digest.
+// The layer only depends on the files, so rebuilding it is reproducible.
+func newLayer(files []layerFile) (blob, string, error) {
+	byName := make(map[string]layerFile)
+	for _, f := range files {
+		byName[f.name] = f
+		for dir := path.Dir(f.name); dir != "."; dir = path.Dir(dir) {
+			if _, ok := byName[dir]; !ok {
+				byName[dir] = layerFile{name: dir, mode: fs.ModeDir | 0755}
+			}
+		}
+	}
+	var tarBuf bytes.Buffer
+	tw := tar.NewWriter(&tarBuf)
+	for _, name := range slices.Sorted(maps.Keys(byName)) {
+		f := byName[name]
+		hdr := &tar.Header{
+			Name:     name,
+			Mode:     int64(f.mode.Perm()),
+			Typeflag: tar.TypeReg,
+			Size:     int64(len(f.data)),
+			ModTime:  time.Unix(0, 0),
+		}
+		if f.mode.IsDir() {
+			hdr.Name += "/"
+			hdr.Typeflag = tar.TypeDir
+			hdr.Size = 0
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return blob{}, "", err
+		}
+		if _, err := tw.Write(f.data); err != nil {
+			return blob{}, "", err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return blob{}, "", err
+	}
+	diffID := digestOf(tarBuf.Bytes())
+	var gzBuf bytes.Buffer
+	gz := gzip.NewWriter(&gzBuf)
+	if _, err := gz.Write(tarBuf.Bytes()); err != nil {
+		return blob{}, "", err
+	}
+	if err := gz.Close(); err != nil {
+		return blob{}, "", err
+	}
+	return newBlob(mediaTypeLayer, gzBuf.Bytes()), diffID, nil
+}
+
+// newIndex returns an index of imgs.
+func newIndex(imgs []ociImage) (blob, error) {
+	idx := ociIndex{SchemaVersion: 2, MediaType: mediaTypeIndex}
+	for _, img := range imgs {
+		d := img.manifest.desc
+		d.Platform = &img.platform
+		idx.Manifests = append(idx.Manifests, d)
+	}
+	return jsonBlob(mediaTypeIndex, idx)
+}
+
+// pushImage uploads img and the blobs it needs to repo in reg.
+func pushImage(
+	ctx context.Context, reg *registry, repo string, img ociImage,
+) error {
+	for _, d := range img.base {
+		ok, err := reg.hasBlob(ctx, repo, d.Digest)
+		if err != nil {
+			return err
+		}
+		if ok {
+			continue
+		}
+		data, err := img.from.blob(ctx, img.fromRepo, d.Digest)
+		if err != nil {
+			return fmt.Errorf("could not get base layer: %w", err)
+		}
+		if err := reg.putBlob(ctx, repo, d.Digest, data); err != nil {
+			return err
+		}
+	}
+	for _, b := range slices.Concat(img.layers, []blob{img.config}) {
+		if err := reg.putBlob(ctx, repo, b.desc.Digest, b.data); err != nil {
+			return err
+		}
+	}
+	return reg.putManifest(ctx, repo, img.manifest.desc.Digest, img.manifest)
+}
+
+// writeLayout writes imgs as an OCI image layout in dir,
+// with each image's manifest named tag.
+func writeLayout(
+	ctx context.Context, sh *command.Sh, dir, tag string, imgs []ociImage,
+) error {
+	write := func(name string, data []byte) error {
+		return sh.WriteFile(ctx, path.Join(dir, name), data)
+	}
+	writeBlob := func(d string, data []byte) error {
+		return write("blobs/sha256/"+strings.TrimPrefix(d, "sha256:"), data)
+	}
+	idx := ociIndex{SchemaVersion: 2, MediaType: mediaTypeIndex}
+	for _, img := range imgs {
+		for _, d := range img.base {
+			data, err := img.from.blob(ctx, img.fromRepo, d.Digest)
+			if err != nil {
+				return fmt.Errorf("could not get base layer: %w", err)
+			}
+			if err := writeBlob(d.Digest, data); err != nil {
+				return err
+			}
+		}
+		blobs := slices.Concat(img.layers, []blob{img.config, img.manifest})
+		for _, b := range blobs {
+			if err := writeBlob(b.desc.Digest, b.data); err != nil {
+				return err
+			}
+		}
+		d := img.manifest.desc
+		d.Platform = &img.platform
+		d.Annotations = map[string]string{
+			"org.opencontainers.image.ref.name": tag,
+		}
+		idx.Manifests = append(idx.Manifests, d)
+	}
+	buf, err := json.Marshal(idx)
+	if err != nil {
+		return err
+	}
+	if err := write("index.json", buf); err != nil {
+		return err
+	}
+	return write("oci-layout", []byte(`{"imageLayoutVersion":"1.0.0"}`))
+}
@@ -0,0 +1,150 @@
+package goapp
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"io"
+	"testing"
+
+	"lesiw.io/command"
+	"lesiw.io/command/mock"
+
+	"labs.lesiw.io/ops/goapp"
+	"labs.lesiw.io/ops/golang"
+)
+
+func TestParseImageRef(t *testing.T) {
+	tests := []struct {
+		in   string
+		want imageRef
+	}{{
+		"alpine",
+		imageRef{"registry-1.docker.io", "library/alpine", "latest"},
+	}, {
+		"bitnami/redis:7",
+		imageRef{"registry-1.docker.io", "bitnami/redis", "7"},
+	}, {
+		"gcr.io/distroless/static-debian12:nonroot",
+		imageRef{"gcr.io", "distroless/static-debian12", "nonroot"},
+	}, {
+		"localhost:5000/app@sha256:abc",
+		imageRef{"localhost:5000", "app", "sha256:abc"},
+	}}
+	for _, tt := range tests {
+		got, err := parseImageRef(tt.in)
+		if err != nil {
+			t.Errorf("parseImageRef(%q) err: %v", tt.in, err)
+		} else if got != tt.want {
+			t.Errorf("parseImageRef(%q) = %+v, want %+v",
+				tt.in, got, tt.want)
+		}
+	}
+	if _, err := parseImageRef("Upper/Case"); err == nil {
+		t.Error("parseImageRef() should fail on upper case names")
+	}
+}
+
+func TestNewLayer(t *testing.T) {
+	files := []layerFile{
+		{name: "app", mode: 0755, data: []byte("bin")},
+		{name: "etc/app/app.toml", mode: 0644, data: []byte("port = 80")},
+	}
+
+	layer, diffID, err := newLayer(files)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	again, _, err := newLayer([]layerFile{files[1], files[0]})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again.desc.Digest != layer.desc.Digest {
+		t.Error("newLayer() is not reproducible")
+	}
+	gz, err := gzip.NewReader(bytes.NewReader(layer.data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, err := io.ReadAll(gz)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if digestOf(raw) != diffID {
+		t.Errorf("diff ID %s is not the digest of the tar", diffID)
+	}
+	var got []string
+	tr := tar.NewReader(bytes.NewReader(raw))
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, hdr.Name)
+	}
+	want := []string{"app", "etc/", "etc/app/", "etc/app/app.toml"}
+	if len(got) != len(want) {
+		t.Fatalf("layer has %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("layer has %v, want %v", got, want)
+			break
+		}
+	}
+}
+
+func TestWriteLayout(t *testing.T) {
+	swap(t, &goapp.Name, "app")
+	swap(t, &goapp.Targets, []golang.Target{
+		{Goos: "linux", Goarch: "arm64"},
+	})
+	ctx := context.Background()
+	sh := command.Shell(new(mock.Machine))
+	swap(t, &golang.Build, sh)
+	swap(t, &golang.Local, sh)
+	err := sh.WriteFile(ctx, "out/app-linux-aarch64", []byte("bin"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	imgs, err := Ops{Image: Image{Base: "scratch"}}.images(ctx, sh, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := writeLayout(ctx, sh, "out/image", "1", imgs); err != nil {
+		t.Fatal(err)
+	}
+
+	buf, err := sh.ReadFile(ctx, "out/image/index.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var idx ociIndex
+	if err := json.Unmarshal(buf, &idx); err != nil {
+		t.Fatal(err)
+	}
+	if len(idx.Manifests) != 1 {
+		t.Fatalf("index has %d manifests, want 1", len(idx.Manifests))
+	}
+	d := idx.Manifests[0]
+	if d.Digest != imgs[0].manifest.desc.Digest ||
+		d.Annotations["org.opencontainers.image.ref.name"] != "1" {
+		t.Errorf("index manifest = %+v, want the image tagged 1", d)
+	}
+	for _, b := range []blob{imgs[0].manifest, imgs[0].config,
+		imgs[0].layers[0]} {
+		name := "out/image/blobs/sha256/" + b.desc.Digest[len("sha256:"):]
+		if _, err := sh.Stat(ctx, name); err != nil {
+			t.Errorf("layout is missing %s: %v", b.desc.MediaType, err)
+		}
+	}
+	if _, err := sh.Stat(ctx, "out/image/oci-layout"); err != nil {
+		t.Errorf("layout is missing oci-layout: %v", err)
+	}
+}
@@ -0,0 +1,258 @@
+package goapp
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/google/go-cmp/cmp"
+	"go.yaml.in/yaml/v3"
+
+	"labs.lesiw.io/ops/golang"
+	"lesiw.io/command"
+)
+
+// Plan prints how deploying would change the live release:
+// every added, removed or changed resource, with a diff of its manifest.
+// Destructive changes, such as removing a resource, are flagged.
+//
+// The new chart is rendered with the live release's image,
+// so only configuration changes are shown.
+func (op Ops) Plan(ctx context.Context) error {
+	op, err := op.environ(ctx)
+	if err != nil {
+		return err
+	}
+	p, err := op.plan(ctx)
+	if err != nil {
+		return err
+	}
+	fmt.Print(p)
+	return nil
+}
+
+// plan compares the application's chart with its live release.
+func (op Ops) plan(ctx context.Context) (*plan, error) {
+	helm, err := op.helm()
+	if err != nil {
+		return nil, err
+	}
+	releases, err := command.Read(ctx, helm, "helm", "list", "-q",
+		"--filter", "^"+regexp.QuoteMeta(op.name())+"$")
+	if err != nil {
+		return nil, fmt.Errorf("could not list helm releases: %w", err)
+	}
+	var live string
+	if releases != "" {
+		live, err = command.Read(ctx, helm,
+			"helm", "get", "manifest", op.name())
+		if err != nil {
+			return nil, fmt.Errorf("could not get release manifest: %w", err)
+		}
+	}
+	old, err := splitManifests(live)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse release manifest: %w", err)
+	}
+	img := liveImage(old, op.name())
+	if img == "" {
+		img = op.repository() + ":render"
+	}
+	chart, err := op.chart(img)
+	if err != nil {
+		return nil, err
+	}
+	rendered, err := op.template(ctx, helm, chart)
+	if err != nil {
+		return nil, err
+	}
+	cur, err := splitManifests(rendered)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse chart: %w", err)
+	}
+	return diffManifests(old, cur), nil
+}
+
+// template renders chart with helm template, so that K8sDefinitions
+// using template directives come out as they would be deployed.
+func (op Ops) template(
+	ctx context.Context, helm command.Machine, chart map[string]string,
+) (string, error) {
+	const dir = "/plan"
+	defer func() { _ = command.Do(ctx, helm, "rm", "-rf", dir) }()
+	if err := writeChart(ctx, helm, dir, chart); err != nil {
+		return "", err
+	}
+	out, err := command.Read(ctx, helm, "helm", "template", op.name(), dir)
+	if err != nil {
+		return "", fmt.Errorf("could not render chart: %w", err)
+	}
+	return out, nil
+}
+
+// confirmPlan asks whether to go ahead with a destructive deploy.
+func (op Ops) confirmPlan(ctx context.Context) error {
+	p, err := op.plan(ctx)
+	if err != nil {
+		return fmt.Errorf("could not plan deploy: %w", err)
+	}
+	if !p.destructive() {
+		return nil
+	}
+	fmt.Print(p)
+	if golang.IsDryRun(ctx) {
+		fmt.Println("dry run: skipping confirmation")
+		return nil
+	}
+	return confirm(os.Stdin, "Deploy anyway? [y/N] ")
+}
+
+func confirm(r io.Reader, prompt string) error {
+	fmt.Print(prompt)
+	answer, err := bufio.NewReader(r).ReadString('\n')
+	if err != nil && !errors.Is(err, io.EOF) {
+		return err
+	}
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return nil
+	}
+	return fmt.Errorf("deploy cancelled")
+}
+
+// resourceKey identifies a resource within a release.
+type resourceKey struct{ kind, name string }
+
+func (k resourceKey) String() string { return k.kind + "/" + k.name }
+
+// splitManifests returns each resource in a stream of manifests,
+// re-encoded with sorted keys so that equal resources compare equal.
+func splitManifests(s string) (map[resourceKey]string, error) {
+	res := make(map[resourceKey]string)
+	dec := yaml.NewDecoder(strings.NewReader(s))
+	for {
+		var doc map[string]any
+		err := dec.Decode(&doc)
+		if errors.Is(err, io.EOF) {
+			return res, nil
+		} else if err != nil {
+			return nil, err
+		}
+		if doc == nil {
+			continue
+		}
+		kind, _ := doc["kind"].(string)
+		meta, _ := doc["metadata"].(map[string]any)
+		name, _ := meta["name"].(string)
+		buf, err := marshalDocs(doc)
+		if err != nil {
+			return nil, err
+		}
+		res[resourceKey{kind, name}] = buf
+	}
+}
+
+// liveImage returns the image of the workload named name in resources,
+// if any.
+func liveImage(resources map[resourceKey]string, name string) string {
+	for _, kind := range []string{"Deployment", "StatefulSet"} {
+		s, ok := resources[resourceKey{kind, name}]
+		if !ok {
+			continue
+		}
+		var w object[workloadSpec]
+		if err := yaml.Unmarshal([]byte(s), &w); err != nil {
+			continue
+		}
+		for _, c := range w.Spec.Template.Spec.Containers {
+			if c.Name == "app" {
+				return c.Image
+			}
+		}
+	}
+	return ""
+}
+
+// A plan is the set of changes between two releases.
+type plan struct {
+	changes []change
+}
+
+type change struct {
+	key         resourceKey
+	op          string // "+", "-" or "~".
+	diff        string // Manifest diff, for changed resources.
+	destructive string // Why the change is destructive, if it is.
+}
+
+// diffManifests returns the changes from old to cur.
+func diffManifests(old, cur map[resourceKey]string) *plan {
+	p := new(plan)
+	keys := slices.Collect(maps.Keys(old))
+	for k := range cur {
+		if _, ok := old[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	slices.SortFunc(keys, func(a, b resourceKey) int {
+		return strings.Compare(a.String(), b.String())
+	})
+	for _, k := range keys {
+		o, inOld := old[k]
+		c, inCur := cur[k]
+		switch {
+		case !inCur:
+			reason := "removed"
+			for n := range cur {
+				if n.name == k.name && isWorkload(n.kind) &&
+					isWorkload(k.kind) {
+					reason = "replaced by " + n.String()
+				}
+			}
+			p.changes = append(p.changes,
+				change{key: k, op: "-", destructive: reason})
+		case !inOld:
+			p.changes = append(p.changes, change{key: k, op: "+"})
+		case o != c:
+			p.changes = append(p.changes, change{key: k, op: "~",
+				diff: cmp.Diff(
+					strings.Split(o, "\n"), strings.Split(c, "\n")),
+			})
+		}
+	}
+	return p
+}
+
+func isWorkload(kind string) bool {
+	return kind == "Deployment" || kind == "StatefulSet"
+}
+
+// destructive reports whether p deletes any resources.
+func (p *plan) destructive() bool {
+	return slices.ContainsFunc(p.changes, func(c change) bool {
+		return c.destructive != ""
+	})
+}
+
+func (p *plan) String() string {
+	if len(p.changes) == 0 {
+		return "No changes.\n"
+	}
+	var buf strings.Builder
+	for _, c := range p.changes {
+		fmt.Fprintf(&buf, "%s %s", c.op, c.key)
+		if c.destructive != "" {
+			fmt.Fprintf(&buf, " (DESTRUCTIVE: %s)", c.destructive)
+		}
+		buf.WriteString("\n")
+		buf.WriteString(c.diff)
+	}
+	return buf.String()
+}
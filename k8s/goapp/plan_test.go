@@ -0,0 +1,141 @@
+package goapp
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"lesiw.io/command/mock"
+
+	"labs.lesiw.io/ops/goapp"
+)
+
+func TestDiffManifests(t *testing.T) {
+	swap(t, &goapp.Name, "app")
+	old, err := splitManifests(mustManifests(t, Ops{Port: 8080}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cur, err := splitManifests(mustManifests(t, Ops{Scalable: true}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := diffManifests(old, cur)
+
+	if !p.destructive() {
+		t.Errorf("plan not destructive:\n%s", p)
+	}
+	got := p.String()
+	for _, want := range []string{
+		"+ Deployment/app\n",
+		"+ HorizontalPodAutoscaler/app\n",
+		"- Service/app (DESTRUCTIVE: removed)\n",
+		"- StatefulSet/app (DESTRUCTIVE: replaced by Deployment/app)\n",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("plan missing %q:\n%s", want, got)
+		}
+	}
+}
+
+func TestDiffManifestsChanged(t *testing.T) {
+	swap(t, &goapp.Name, "app")
+	old, err := splitManifests(mustManifests(t, Ops{Memory: 32}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cur, err := splitManifests(mustManifests(t, Ops{Memory: 64}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := diffManifests(old, cur)
+
+	if p.destructive() {
+		t.Errorf("plan is destructive:\n%s", p)
+	}
+	if len(p.changes) != 1 || p.changes[0].op != "~" {
+		t.Fatalf("want one changed resource, got:\n%s", p)
+	}
+	if !strings.Contains(p.changes[0].diff, "64Mi") {
+		t.Errorf("diff missing new memory:\n%s", p.changes[0].diff)
+	}
+	if got := diffManifests(old, old).String(); got != "No changes.\n" {
+		t.Errorf("diff of identical manifests = %q", got)
+	}
+}
+
+func TestLiveImage(t *testing.T) {
+	swap(t, &goapp.Name, "app")
+	res, err := splitManifests(mustManifests(t, Ops{Scalable: true}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := liveImage(res, "app"), "registry.test/app:1"; got != want {
+		t.Errorf("liveImage() = %q, want %q", got, want)
+	}
+}
+
+func TestPlanTemplatedDefinitions(t *testing.T) {
+	swap(t, &goapp.Name, "app")
+	m := setupCluster(t)
+	op := Ops{K8sDefinitions: `apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: {{ .Release.Name }}-extra
+`}
+	// Helm renders the definitions the same way for both sides.
+	rendered := mustManifests(t, op) + `---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: app-extra
+`
+	m.Return(strings.NewReader("app\n"), "helm", "list")
+	m.Return(strings.NewReader(rendered), "helm", "get", "manifest")
+	m.Return(strings.NewReader(rendered), "helm", "template")
+
+	p, err := op.plan(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := p.String(); got != "No changes.\n" {
+		t.Errorf("plan = %q, want no changes", got)
+	}
+	tmpl := mock.Calls(m, "helm", "template")
+	if len(tmpl) != 1 || tmpl[0].Args[3] != "/plan" {
+		t.Errorf("helm template calls = %v, want one of /plan", tmpl)
+	}
+}
+
+func TestConfirm(t *testing.T) {
+	for answer, ok := range map[string]bool{
+		"y\n":   true,
+		"YES\n": true,
+		"n\n":   false,
+		"\n":    false,
+		"":      false,
+	} {
+		err := confirm(strings.NewReader(answer), "")
+		if got := err == nil; got != ok {
+			t.Errorf("confirm(%q) = %v, want ok=%v", answer, err, ok)
+		}
+	}
+}
+
+func mustManifests(t *testing.T, op Ops) string {
+	t.Helper()
+	return mustManifestsFor(t, op, "registry.test/app:1")
+}
+
+func mustManifestsFor(t *testing.T, op Ops, img string) string {
+	t.Helper()
+	s, err := op.manifests(img)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
@@ -0,0 +1,94 @@
+package goapp
+
+import "fmt"
+
+// A Probe checks the health of the application's container.
+//
+// Exactly one of Path, TCP or Command selects how the container is
+// checked. A Probe with none of them set disables the check.
+type Probe struct {
+	Path    string   // HTTP path to GET, such as /healthz.
+	TCP     bool     // Whether to check that the port accepts connections.
+	Command []string // Command to run in the container.
+
+	Port int // Port for HTTP and TCP checks. Defaults to Ops.Port.
+
+	InitialDelaySeconds int // Delay before the first check.
+	PeriodSeconds       int // Time between checks.
+	TimeoutSeconds      int // Time before a check fails.
+	FailureThreshold    int // Failed checks before the probe fails.
+}
+
+// Default probes for applications with a Port. The startup probe gives
+// the application a minute to start listening before the liveness probe
+// takes over.
+var (
+	defaultLivenessProbe  = Probe{TCP: true, PeriodSeconds: 10}
+	defaultReadinessProbe = Probe{TCP: true, PeriodSeconds: 5}
+	defaultStartupProbe   = Probe{
+		TCP:              true,
+		PeriodSeconds:    2,
+		FailureThreshold: 30,
+	}
+)
+
+// probes sets the probes of c from op.
+func (op Ops) probes(c *container) error {
+	var err error
+	if c.LivenessProbe, err = op.containerProbe(
+		op.LivenessProbe, defaultLivenessProbe); err != nil {
+		return fmt.Errorf("bad liveness probe: %w", err)
+	}
+	if c.ReadinessProbe, err = op.containerProbe(
+		op.ReadinessProbe, defaultReadinessProbe); err != nil {
+		return fmt.Errorf("bad readiness probe: %w", err)
+	}
+	if c.StartupProbe, err = op.containerProbe(
+		op.StartupProbe, defaultStartupProbe); err != nil {
+		return fmt.Errorf("bad startup probe: %w", err)
+	}
+	return nil
+}
+
+// containerProbe returns the manifest for p,
+// or for def if p is nil and the application has a Port.
+func (op Ops) containerProbe(p *Probe, def Probe) (*probe, error) {
+	if p == nil {
+		if op.Port == 0 {
+			return nil, nil
+		}
+		p = &def
+	}
+	pr := &probe{
+		InitialDelaySeconds: p.InitialDelaySeconds,
+		PeriodSeconds:       p.PeriodSeconds,
+		TimeoutSeconds:      p.TimeoutSeconds,
+		FailureThreshold:    p.FailureThreshold,
+	}
+	port := p.Port
+	if port == 0 {
+		port = op.Port
+	}
+	var handlers int
+	if p.Path != "" {
+		handlers++
+		pr.HTTPGet = &httpGetAction{Path: p.Path, Port: port}
+	}
+	if p.TCP {
+		handlers++
+		pr.TCPSocket = &tcpSocketAction{Port: port}
+	}
+	if len(p.Command) > 0 {
+		handlers++
+		pr.Exec = &execAction{Command: p.Command}
+	}
+	switch {
+	case handlers == 0:
+		return nil, nil
+	case handlers > 1:
+		return nil, fmt.Errorf("more than one of Path, TCP and Command set")
+	case port == 0 && pr.Exec == nil:
+		return nil, fmt.Errorf("no port to check")
+	}
+	return pr, nil
+}
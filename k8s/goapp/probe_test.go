@@ -0,0 +1,74 @@
+package goapp
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestContainerProbe(t *testing.T) {
+	def := Probe{TCP: true, PeriodSeconds: 5}
+	tests := []struct {
+		name  string
+		port  int
+		probe *Probe
+		want  *probe
+	}{{
+		name: "default",
+		port: 8080,
+		want: &probe{
+			TCPSocket:     &tcpSocketAction{Port: 8080},
+			PeriodSeconds: 5,
+		},
+	}, {
+		name: "no port",
+	}, {
+		name:  "http",
+		port:  8080,
+		probe: &Probe{Path: "/healthz", FailureThreshold: 3},
+		want: &probe{
+			HTTPGet:          &httpGetAction{Path: "/healthz", Port: 8080},
+			FailureThreshold: 3,
+		},
+	}, {
+		name:  "http port",
+		port:  8080,
+		probe: &Probe{Path: "/healthz", Port: 9090},
+		want: &probe{
+			HTTPGet: &httpGetAction{Path: "/healthz", Port: 9090},
+		},
+	}, {
+		name:  "exec",
+		probe: &Probe{Command: []string{"/app", "-health"}},
+		want: &probe{
+			Exec: &execAction{Command: []string{"/app", "-health"}},
+		},
+	}, {
+		name:  "disabled",
+		port:  8080,
+		probe: &Probe{},
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Ops{Port: tt.port}.containerProbe(tt.probe, def)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !cmp.Equal(tt.want, got) {
+				t.Errorf("probe: -want +got\n%s", cmp.Diff(tt.want, got))
+			}
+		})
+	}
+}
+
+func TestContainerProbeInvalid(t *testing.T) {
+	for _, p := range []*Probe{
+		{Path: "/healthz", TCP: true},
+		{TCP: true},
+	} {
+		_, err := Ops{}.containerProbe(p, Probe{})
+		if err == nil {
+			t.Errorf("containerProbe(%+v) succeeded, want error", p)
+		}
+	}
+}
@@ -0,0 +1,341 @@
+package goapp
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// A registry is a client of a container registry's HTTP API.
+type registry struct {
+	host     string
+	user     string
+	password string
+	client   *http.Client
+
+	mu     sync.Mutex
+	tokens map[string]string // Bearer tokens, by scope.
+}
+
+func newRegistry(host, user, password string) *registry {
+	return &registry{
+		host:     host,
+		user:     user,
+		password: password,
+		client:   http.DefaultClient,
+		tokens:   make(map[string]string),
+	}
+}
+
+// url returns the URL of path in repo's API.
+// Registries on the loopback interface are reached over plain HTTP.
+func (r *registry) url(repo, path string) string {
+	scheme := "https"
+	host, _, err := net.SplitHostPort(r.host)
+	if err != nil {
+		host = r.host
+	}
+	if ip := net.ParseIP(host); host == "localhost" ||
+		ip != nil && ip.IsLoopback() {
+		scheme = "http"
+	}
+	return scheme + "://" + r.host + "/v2/" + repo + path
+}
+
+// do sends the request made by req, authenticating with the registry if
+// it asks to. scope is the access the request needs, as in
+// repository:app:push,pull.
+func (r *registry) do(
+	ctx context.Context, scope string,
+	req func() (*http.Request, error),
+) (*http.Response, error) {
+	for retry := true; ; retry = false {
+		q, err := req()
+		if err != nil {
+			return nil, err
+		}
+		q = q.WithContext(ctx)
+		r.mu.Lock()
+		token := r.tokens[scope]
+		r.mu.Unlock()
+		if token != "" {
+			q.Header.Set("Authorization", "Bearer "+token)
+		} else if r.user != "" {
+			q.SetBasicAuth(r.user, r.password)
+		}
+		resp, err := r.client.Do(q)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode != http.StatusUnauthorized || !retry {
+			return resp, nil
+		}
+		challenge := resp.Header.Get("WWW-Authenticate")
+		if err := resp.Body.Close(); err != nil {
+			return nil, err
+		}
+		if err := r.authorize(ctx, scope, challenge); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// authorize fetches a token for scope from the token server named in a
+// WWW-Authenticate challenge.
+func (r *registry) authorize(
+	ctx context.Context, scope, challenge string,
+) error {
+	scheme, params, _ := strings.Cut(challenge, " ")
+	if !strings.EqualFold(scheme, "Bearer") {
+		if r.user == "" {
+			return fmt.Errorf("%s requires credentials", r.host)
+		}
+		return fmt.Errorf("%s rejected credentials for %s", r.host, r.user)
+	}
+	p := parseChallenge(params)
+	u, err := url.Parse(p["realm"])
+	if err != nil || p["realm"] == "" {
+		return fmt.Errorf("bad auth challenge from %s: %q",
+			r.host, challenge)
+	}
+	query := u.Query()
+	if p["service"] != "" {
+		query.Set("service", p["service"])
+	}
+	query.Set("scope", scope)
+	u.RawQuery = query.Encode()
+	q, err := http.NewRequestWithContext(ctx, "GET", u.String(), nil)
+	if err != nil {
+		return err
+	}
+	if r.user != "" {
+		q.SetBasicAuth(r.user, r.password)
+	}
+	resp, err := r.client.Do(q)
+	if err != nil {
+		return fmt.Errorf("could not get token from %s: %w", u.Host, err)
+	}
+	defer resp.Body.Close()
+	if err := checkStatus(resp, http.StatusOK); err != nil {
+		return fmt.Errorf("could not get token from %s: %w", u.Host, err)
+	}
+	var tok struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&tok); err != nil {
+		return fmt.Errorf("could not parse token from %s: %w", u.Host, err)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if tok.AccessToken != "" {
+		r.tokens[scope] = tok.AccessToken
+	} else {
+		r.tokens[scope] = tok.Token
+	}
+	return nil
+}
+
+// parseChallenge parses the parameters of a WWW-Authenticate challenge,
+// as in realm="https://auth.example.com/token",service="example.com".
+func parseChallenge(s string) map[string]string {
+	params := make(map[string]string)
+	for s != "" {
+		var k, v string
+		k, s, _ = strings.Cut(s, "=")
+		k = strings.ToLower(strings.TrimSpace(k))
+		if strings.HasPrefix(s, `"`) {
+			v, s, _ = strings.Cut(s[1:], `"`)
+			_, s, _ = strings.Cut(s, ",")
+		} else {
+			v, s, _ = strings.Cut(s, ",")
+		}
+		params[k] = strings.TrimSpace(v)
+	}
+	return params
+}
+
+func checkStatus(resp *http.Response, want ...int) error {
+	if slices.Contains(want, resp.StatusCode) {
+		return nil
+	}
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	if len(body) > 0 {
+		return fmt.Errorf("%s: %s", resp.Status, bytes.TrimSpace(body))
+	}
+	return fmt.Errorf("%s", resp.Status)
+}
+
+func pullScope(repo string) string { return "repository:" + repo + ":pull" }
+func pushScope(repo string) string {
+	return "repository:" + repo + ":pull,push"
+}
+
+// manifestTypes are the manifest media types that registries are asked
+// for, in order of preference.
+var manifestTypes = []string{
+	mediaTypeIndex,
+	mediaTypeManifest,
+	dockerManifestList,
+	dockerManifest,
+}
+
+// manifest returns the manifest or index that ref names in repo.
+func (r *registry) manifest(
+	ctx context.Context, repo, ref string,
+) (blob, error) {
+	u := r.url(repo, "/manifests/"+ref)
+	resp, err := r.do(ctx, pullScope(repo), func() (*http.Request, error) {
+		q, err := http.NewRequest("GET", u, nil)
+		if err != nil {
+			return nil, err
+		}
+		q.Header.Set("Accept", strings.Join(manifestTypes, ", "))
+		return q, nil
+	})
+	if err != nil {
+		return blob{}, fmt.Errorf("could not get manifest %s: %w", ref, err)
+	}
+	defer resp.Body.Close()
+	if err := checkStatus(resp, http.StatusOK); err != nil {
+		return blob{}, fmt.Errorf("could not get manifest %s: %w", ref, err)
+	}
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return blob{}, fmt.Errorf("could not read manifest %s: %w", ref, err)
+	}
+	mediaType, _, _ := strings.Cut(resp.Header.Get("Content-Type"), ";")
+	return newBlob(mediaType, data), nil
+}
+
+// blob returns the data of the blob with digest d in repo.
+func (r *registry) blob(
+	ctx context.Context, repo, d string,
+) ([]byte, error) {
+	u := r.url(repo, "/blobs/"+d)
+	resp, err := r.do(ctx, pullScope(repo), func() (*http.Request, error) {
+		return http.NewRequest("GET", u, nil)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not get blob %s: %w", d, err)
+	}
+	defer resp.Body.Close()
+	if err := checkStatus(resp, http.StatusOK); err != nil {
+		return nil, fmt.Errorf("could not get blob %s: %w", d, err)
+	}
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("could not read blob %s: %w", d, err)
+	}
+	if got := digestOf(data); got != d {
+		return nil, fmt.Errorf("blob %s has digest %s", d, got)
+	}
+	return data, nil
+}
+
+// hasBlob reports whether repo has the blob with digest d.
+func (r *registry) hasBlob(
+	ctx context.Context, repo, d string,
+) (bool, error) {
+	u := r.url(repo, "/blobs/"+d)
+	resp, err := r.do(ctx, pushScope(repo), func() (*http.Request, error) {
+		return http.NewRequest("HEAD", u, nil)
+	})
+	if err != nil {
+		return false, fmt.Errorf("could not check blob %s: %w", d, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return false, nil
+	}
+	if err := checkStatus(resp, http.StatusOK); err != nil {
+		return false, fmt.Errorf("could not check blob %s: %w", d, err)
+	}
+	return true, nil
+}
+
+// putBlob uploads data to repo as the blob with digest d,
+// unless repo already has it.
+func (r *registry) putBlob(
+	ctx context.Context, repo, d string, data []byte,
+) error {
+	if ok, err := r.hasBlob(ctx, repo, d); err != nil || ok {
+		return err
+	}
+	start := r.url(repo, "/blobs/uploads/")
+	resp, err := r.do(ctx, pushScope(repo), func() (*http.Request, error) {
+		return http.NewRequest("POST", start, nil)
+	})
+	if err != nil {
+		return fmt.Errorf("could not start upload of %s: %w", d, err)
+	}
+	err = errors.Join(checkStatus(resp, http.StatusAccepted),
+		resp.Body.Close())
+	if err != nil {
+		return fmt.Errorf("could not start upload of %s: %w", d, err)
+	}
+	loc, err := resp.Request.URL.Parse(resp.Header.Get("Location"))
+	if err != nil {
+		return fmt.Errorf("bad upload location for %s: %w", d, err)
+	}
+	query := loc.Query()
+	query.Set("digest", d)
+	loc.RawQuery = query.Encode()
+	resp, err = r.do(ctx, pushScope(repo), func() (*http.Request, error) {
+		q, err := http.NewRequest("PUT", loc.String(),
+			bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		q.Header.Set("Content-Type", "application/octet-stream")
+		return q, nil
+	})
+	if err != nil {
+		return fmt.Errorf("could not upload %s: %w", d, err)
+	}
+	defer resp.Body.Close()
+	if err := checkStatus(resp, http.StatusCreated); err != nil {
+		return fmt.Errorf("could not upload %s: %w", d, err)
+	}
+	return nil
+}
+
+// putManifest uploads b to repo as the manifest named ref,
+// which is either a tag or b's digest.
+func (r *registry) putManifest(
+	ctx context.Context, repo, ref string, b blob,
+) error {
+	u := r.url(repo, "/manifests/"+ref)
+	resp, err := r.do(ctx, pushScope(repo), func() (*http.Request, error) {
+		q, err := http.NewRequest("PUT", u, bytes.NewReader(b.data))
+		if err != nil {
+			return nil, err
+		}
+		q.Header.Set("Content-Type", b.desc.MediaType)
+		return q, nil
+	})
+	if err != nil {
+		return fmt.Errorf("could not put manifest %s: %w", ref, err)
+	}
+	defer resp.Body.Close()
+	if err := checkStatus(resp, http.StatusCreated); err != nil {
+		return fmt.Errorf("could not put manifest %s: %w", ref, err)
+	}
+	return nil
+}
+
+func digestOf(data []byte) string {
+	sum := sha256.Sum256(data)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,195 @@
+package goapp
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// A fakeRegistry is an in-memory registry with enough of the
+// distribution API to push and pull images.
+type fakeRegistry struct {
+	host string
+
+	// If token is set, requests need it as a bearer token,
+	// which the token server only gives to user and password.
+	token, user, password string
+
+	mu        sync.Mutex
+	blobs     map[string][]byte // By repo@digest.
+	manifests map[string]blob   // By repo:tag and repo@digest.
+	uploads   int
+}
+
+func newFakeRegistry(t *testing.T) *fakeRegistry {
+	t.Helper()
+	f := &fakeRegistry{
+		blobs:     make(map[string][]byte),
+		manifests: make(map[string]blob),
+	}
+	srv := httptest.NewServer(f)
+	t.Cleanup(srv.Close)
+	f.host = strings.TrimPrefix(srv.URL, "http://")
+	return f
+}
+
+func (f *fakeRegistry) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/token" {
+		user, password, _ := r.BasicAuth()
+		if user != f.user || password != f.password {
+			http.Error(w, "bad credentials", http.StatusUnauthorized)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]string{"token": f.token})
+		return
+	}
+	if f.token != "" && r.Header.Get("Authorization") != "Bearer "+f.token {
+		w.Header().Set("WWW-Authenticate", fmt.Sprintf(
+			`Bearer realm="http://%s/token",service="fake"`, f.host))
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	path := strings.TrimPrefix(r.URL.Path, "/v2/")
+	if repo, _, ok := strings.Cut(path, "/blobs/uploads/"); ok {
+		switch r.Method {
+		case "POST":
+			f.uploads++
+			w.Header().Set("Location",
+				fmt.Sprintf("/v2/%s/blobs/uploads/%d", repo, f.uploads))
+			w.WriteHeader(http.StatusAccepted)
+		case "PUT":
+			data, _ := io.ReadAll(r.Body)
+			d := r.URL.Query().Get("digest")
+			if digestOf(data) != d {
+				http.Error(w, "digest mismatch", http.StatusBadRequest)
+				return
+			}
+			f.blobs[repo+"@"+d] = data
+			w.WriteHeader(http.StatusCreated)
+		}
+		return
+	}
+	if i := strings.LastIndex(path, "/blobs/"); i >= 0 {
+		data, ok := f.blobs[path[:i]+"@"+path[i+len("/blobs/"):]]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = w.Write(data)
+		return
+	}
+	i := strings.LastIndex(path, "/manifests/")
+	if i < 0 {
+		http.NotFound(w, r)
+		return
+	}
+	repo, ref := path[:i], path[i+len("/manifests/"):]
+	switch r.Method {
+	case "PUT":
+		data, _ := io.ReadAll(r.Body)
+		f.putManifest(repo, ref, newBlob(r.Header.Get("Content-Type"), data))
+		w.WriteHeader(http.StatusCreated)
+	case "GET":
+		b, ok := f.manifest(repo, ref)
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", b.desc.MediaType)
+		_, _ = w.Write(b.data)
+	}
+}
+
+func (f *fakeRegistry) putManifest(repo, ref string, b blob) {
+	f.manifests[repo+"@"+b.desc.Digest] = b
+	if !strings.HasPrefix(ref, "sha256:") {
+		f.manifests[repo+":"+ref] = b
+	}
+}
+
+func (f *fakeRegistry) manifest(repo, ref string) (blob, bool) {
+	if strings.HasPrefix(ref, "sha256:") {
+		b, ok := f.manifests[repo+"@"+ref]
+		return b, ok
+	}
+	b, ok := f.manifests[repo+":"+ref]
+	return b, ok
+}
+
+func TestRegistry(t *testing.T) {
+	ctx := context.Background()
+	f := newFakeRegistry(t)
+	reg := newRegistry(f.host, "", "")
+	data := []byte("layer")
+	d := digestOf(data)
+
+	if err := reg.putBlob(ctx, "app", d, data); err != nil {
+		t.Fatal(err)
+	}
+	if err := reg.putBlob(ctx, "app", d, data); err != nil {
+		t.Fatal(err)
+	}
+	m := newBlob(mediaTypeManifest, []byte(`{"schemaVersion":2}`))
+	if err := reg.putManifest(ctx, "app", "v1", m); err != nil {
+		t.Fatal(err)
+	}
+
+	if f.uploads != 1 {
+		t.Errorf("uploads = %d, want 1", f.uploads)
+	}
+	got, err := reg.blob(ctx, "app", d)
+	if err != nil || string(got) != "layer" {
+		t.Errorf("blob() = %q, %v, want layer", got, err)
+	}
+	if ok, err := reg.hasBlob(ctx, "other", d); ok || err != nil {
+		t.Errorf("hasBlob(other) = %t, %v, want false", ok, err)
+	}
+	gotm, err := reg.manifest(ctx, "app", "v1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gotm.desc.Digest != m.desc.Digest ||
+		gotm.desc.MediaType != m.desc.MediaType {
+		t.Errorf("manifest() = %+v, want %+v", gotm.desc, m.desc)
+	}
+}
+
+func TestRegistryAuth(t *testing.T) {
+	ctx := context.Background()
+	f := newFakeRegistry(t)
+	f.token, f.user, f.password = "t0k3n", "ll", "hunter2"
+	data := []byte("layer")
+
+	err := newRegistry(f.host, "ll", "wrong").
+		putBlob(ctx, "app", digestOf(data), data)
+	if err == nil {
+		t.Error("putBlob() with a bad password should fail")
+	}
+	err = newRegistry(f.host, "ll", "hunter2").
+		putBlob(ctx, "app", digestOf(data), data)
+	if err != nil {
+		t.Errorf("putBlob() err: %v", err)
+	}
+}
+
+func TestParseChallenge(t *testing.T) {
+	got := parseChallenge(`realm="https://auth.docker.io/token",` +
+		`service="registry.docker.io",scope="repository:a/b:pull,push"`)
+
+	want := map[string]string{
+		"realm":   "https://auth.docker.io/token",
+		"service": "registry.docker.io",
+		"scope":   "repository:a/b:pull,push",
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("parseChallenge() = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,61 @@
+package goapp
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"slices"
+
+	"labs.lesiw.io/ops/goapp"
+	"labs.lesiw.io/ops/golang"
+	"lesiw.io/command"
+	"lesiw.io/command/sys"
+	"lesiw.io/fs/path"
+)
+
+// Render builds the application's Helm chart without a cluster,
+// registry or spkez. If CHART_DIR is set in the environment, the chart is
+// written to that directory; otherwise its manifests are printed.
+//
+// The chart names a fixed image instead of a freshly pushed one,
+// so that charts rendered from different commits can be diffed.
+func (op Ops) Render(ctx context.Context) error {
+	if goapp.Name == "" {
+		return fmt.Errorf("no app name given")
+	}
+	op, err := op.environ(ctx)
+	if err != nil {
+		return err
+	}
+	return op.render(ctx, os.Stdout)
+}
+
+func (op Ops) render(ctx context.Context, w io.Writer) error {
+	manifests, err := op.manifests(op.repository() + ":render")
+	if err != nil {
+		return err
+	}
+	dir := golang.Local.Env(ctx, "CHART_DIR")
+	if dir == "" {
+		_, err := io.WriteString(w, manifests)
+		return err
+	}
+	chart, err := op.chart(op.repository() + ":render")
+	if err != nil {
+		return err
+	}
+	sh := command.Shell(sys.Machine())
+	for _, name := range slices.Sorted(maps.Keys(chart)) {
+		file := path.Join(dir, name)
+		if err := sh.MkdirAll(ctx, path.Dir(file)); err != nil {
+			return err
+		}
+		err := sh.WriteFile(ctx, file, []byte(chart[name]))
+		if err != nil {
+			return fmt.Errorf("could not write %s: %w", file, err)
+		}
+	}
+	return nil
+}
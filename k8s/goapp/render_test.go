@@ -0,0 +1,57 @@
+package goapp
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"labs.lesiw.io/ops/goapp"
+)
+
+func TestRender(t *testing.T) {
+	swap(t, &goapp.Name, "app")
+	t.Setenv("CHART_DIR", "")
+	op := Ops{Port: 8080, Registry: "registry.test"}
+
+	var buf strings.Builder
+	if err := op.render(context.Background(), &buf); err != nil {
+		t.Fatal(err)
+	}
+
+	want := mustManifestsFor(t, op, "registry.test/app:render")
+	if got := buf.String(); got != want {
+		t.Errorf("render() printed:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestRenderChartDir(t *testing.T) {
+	swap(t, &goapp.Name, "app")
+	dir := t.TempDir()
+	t.Setenv("CHART_DIR", dir)
+	op := Ops{Port: 8080, Registry: "registry.test"}
+
+	var buf strings.Builder
+	if err := op.render(context.Background(), &buf); err != nil {
+		t.Fatal(err)
+	}
+
+	if buf.Len() > 0 {
+		t.Errorf("render() printed %q, want nothing", buf.String())
+	}
+	chart, err := op.chart("registry.test/app:render")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"Chart.yaml", chartTemplate} {
+		got, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Errorf("chart file %s: %v", name, err)
+			continue
+		}
+		if string(got) != chart[name] {
+			t.Errorf("%s = %q, want %q", name, got, chart[name])
+		}
+	}
+}
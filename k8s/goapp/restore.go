@@ -0,0 +1,318 @@
+package goapp
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"labs.lesiw.io/ops/golang"
+	"lesiw.io/command"
+	"lesiw.io/command/sub"
+	"lesiw.io/command/sys"
+)
+
+// Restore restores the application's database from a backup made by
+// Backup. The backup is chosen by its timestamp, as in 20260102T030405Z,
+// from BACKUP in the environment, and defaults to the latest backup.
+//
+// The application is scaled to zero while the backup is restored, and is
+// only scaled back up once the restored tables have been verified.
+func (op Ops) Restore(ctx context.Context) error {
+	op, err := op.environ(ctx)
+	if err != nil {
+		return err
+	}
+	if !op.Postgres {
+		return fmt.Errorf("%s has no database to restore", op.name())
+	}
+	kubectl, err := op.kubectl()
+	if err != nil {
+		return err
+	}
+	store, err := op.backupStore(ctx)
+	if err != nil {
+		return fmt.Errorf("could not open backup store: %w", err)
+	}
+	names, err := store.list(ctx)
+	if err != nil {
+		return fmt.Errorf("could not list backups: %w", err)
+	}
+	name, err := chooseBackup(
+		names, op.name(), golang.Local.Env(ctx, "BACKUP"))
+	if err != nil {
+		return err
+	}
+	sh := command.Shell(sys.Machine())
+	tmp, err := sh.Temp(ctx, "backup")
+	if err != nil {
+		return err
+	}
+	defer sh.Remove(ctx, tmp.Path())
+	err = fetchBackup(ctx, store, name, tmp)
+	_ = tmp.Close()
+	if err != nil {
+		return fmt.Errorf("could not fetch backup %s: %w", name, err)
+	}
+	fmt.Println("Restoring", op.name(), "from", name)
+
+	kind := op.workload()
+	workload := kind + "/" + op.name()
+	replicas, err := command.Read(ctx, kubectl,
+		"get", kind, op.name(), "-o", "jsonpath={.spec.replicas}")
+	if err != nil {
+		return fmt.Errorf("could not get %s replicas: %w", kind, err)
+	}
+	if err := scale(ctx, kubectl, workload, "0"); err != nil {
+		return err
+	}
+	tables, err := restoreBackup(
+		ctx, op.postgres(kubectl, "-i"), sh, tmp.Path(), op.name())
+	if err != nil {
+		// The restore runs in a single transaction,
+		// so the database is as it was before.
+		if serr := scale(ctx, kubectl, workload, replicas); serr != nil {
+			err = fmt.Errorf("%w; could not scale back up: %w", err, serr)
+		}
+		return fmt.Errorf("could not restore %s: %w", name, err)
+	}
+	if !golang.IsDryRun(ctx) {
+		pg := op.postgres(kubectl)
+		if err := verifyRestore(ctx, pg, op.name(), tables); err != nil {
+			return fmt.Errorf("%s left scaled to zero: %w", kind, err)
+		}
+	}
+	return scale(ctx, kubectl, workload, replicas)
+}
+
+// workload returns the kind of the application's workload.
+func (op Ops) workload() string {
+	if op.Scalable {
+		return "deployment"
+	}
+	return "statefulset"
+}
+
+// scale scales workload, such as deployment/app, to replicas.
+// When scaling to zero, it waits for the workload's pods to stop.
+func scale(
+	ctx context.Context, kubectl command.Machine, workload, replicas string,
+) error {
+	err := golang.Mutate(ctx, kubectl,
+		"scale", workload, "--replicas="+replicas)
+	if err != nil {
+		return fmt.Errorf("could not scale %s to %s: %w",
+			workload, replicas, err)
+	}
+	_, app, _ := strings.Cut(workload, "/")
+	if replicas != "0" {
+		return nil
+	}
+	// kubectl wait fails if no pods match a selector,
+	// so wait for the pods by name, if there are any.
+	pods, err := command.Read(ctx, kubectl,
+		"get", "pod", "-l", "app="+app, "-o", "name")
+	if err != nil {
+		return fmt.Errorf("could not list pods: %w", err)
+	}
+	if pods == "" {
+		return nil
+	}
+	args := append([]string{"wait", "--for=delete"}, strings.Fields(pods)...)
+	err = golang.Mutate(ctx, kubectl, append(args, "--timeout=5m")...)
+	if err != nil {
+		return fmt.Errorf("could not wait for pods to stop: %w", err)
+	}
+	return nil
+}
+
+// chooseBackup returns the complete backup of app with the given
+// timestamp, or its latest complete backup if timestamp is empty.
+func chooseBackup(names []string, app, timestamp string) (string, error) {
+	var backups []string
+	prefix, suffix := app+"-", ".sql.gz"
+	for _, name := range names {
+		ts, ok := strings.CutPrefix(name, prefix)
+		if !ok {
+			continue
+		}
+		ts, ok = strings.CutSuffix(ts, suffix)
+		if !ok {
+			continue
+		}
+		if _, err := time.Parse(backupTime, ts); err != nil {
+			continue
+		}
+		if !slices.Contains(names, name+".sha256") {
+			continue // Incomplete.
+		}
+		backups = append(backups, name)
+	}
+	if len(backups) == 0 {
+		return "", fmt.Errorf("no backups found for %s", app)
+	}
+	slices.Sort(backups)
+	if timestamp == "" {
+		return backups[len(backups)-1], nil
+	}
+	name := prefix + timestamp + suffix
+	if !slices.Contains(backups, name) {
+		return "", fmt.Errorf("no backup found at %s", timestamp)
+	}
+	return name, nil
+}
+
+// fetchBackup copies the backup name from store to w,
+// checking it against its checksum file.
+func fetchBackup(
+	ctx context.Context, store backupStore, name string, w io.Writer,
+) error {
+	r, err := store.get(ctx, name+".sha256")
+	if err != nil {
+		return err
+	}
+	sumFile, err := io.ReadAll(r)
+	_ = r.Close()
+	if err != nil {
+		return err
+	}
+	want, _, _ := strings.Cut(string(sumFile), " ")
+	r, err = store.get(ctx, name)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	h := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(w, h), r); err != nil {
+		return err
+	}
+	if got := hex.EncodeToString(h.Sum(nil)); got != want {
+		return fmt.Errorf("checksum mismatch: got %s, want %s", got, want)
+	}
+	return nil
+}
+
+// restoreBackup loads the gzipped dump at file into the database db
+// with psql on pg, in a single transaction.
+// It returns the number of tables created by the dump in each schema.
+func restoreBackup(
+	ctx context.Context, pg command.Machine, sh *command.Sh,
+	file, db string,
+) (map[string]int, error) {
+	f, err := sh.Open(ctx, file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, err
+	}
+	counter := &tableCounter{}
+	err = golang.MutateFrom(ctx, pg, io.TeeReader(gz, counter),
+		"psql", "-v", "ON_ERROR_STOP=1", "--single-transaction",
+		"-d", db,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return counter.tables, nil
+}
+
+// verifyRestore checks that each schema in want has as many tables in
+// the database db on pg as the restored dump created there.
+// Tables that belong to extensions, such as PostGIS's spatial_ref_sys,
+// are not counted, since the dump creates the extension instead.
+func verifyRestore(
+	ctx context.Context, pg command.Machine, db string,
+	want map[string]int,
+) error {
+	psql := sub.Machine(pg, "psql", "-d", db, "-tA", "-c")
+	out, err := command.Read(ctx, psql, `SELECT n.nspname, count(*)
+FROM pg_catalog.pg_class c
+JOIN pg_catalog.pg_namespace n ON n.oid = c.relnamespace
+WHERE c.relkind IN ('r', 'p')
+AND NOT EXISTS (
+   SELECT FROM pg_catalog.pg_depend d
+   WHERE d.classid = 'pg_catalog.pg_class'::regclass
+   AND d.objid = c.oid AND d.deptype = 'e')
+GROUP BY n.nspname;`)
+	if err != nil {
+		return fmt.Errorf("could not count restored tables: %w", err)
+	}
+	got := make(map[string]int)
+	for line := range strings.Lines(strings.TrimSpace(out)) {
+		line = strings.TrimSpace(line)
+		i := strings.LastIndex(line, "|")
+		if i < 0 {
+			return fmt.Errorf("bad table count %q", line)
+		}
+		n, err := strconv.Atoi(line[i+1:])
+		if err != nil {
+			return fmt.Errorf("bad table count %q: %w", line, err)
+		}
+		got[line[:i]] = n
+	}
+	for _, schema := range slices.Sorted(maps.Keys(want)) {
+		if got[schema] != want[schema] {
+			return fmt.Errorf("restored %d tables in %s, want %d",
+				got[schema], schema, want[schema])
+		}
+	}
+	return nil
+}
+
+// tableCounter counts the CREATE TABLE statements written to it,
+// by schema.
+type tableCounter struct {
+	tables  map[string]int
+	partial []byte
+}
+
+func (c *tableCounter) Write(p []byte) (int, error) {
+	c.partial = append(c.partial, p...)
+	for {
+		line, rest, ok := bytes.Cut(c.partial, []byte("\n"))
+		if !ok {
+			break
+		}
+		if name, ok := bytes.CutPrefix(line, []byte("CREATE TABLE ")); ok {
+			if c.tables == nil {
+				c.tables = make(map[string]int)
+			}
+			c.tables[tableSchema(string(name))]++
+		}
+		c.partial = rest
+	}
+	return len(p), nil
+}
+
+// tableSchema returns the unquoted schema of the qualified table name
+// that starts def, as in public.users ( or "My Schema"."users" (.
+func tableSchema(def string) string {
+	if rest, ok := strings.CutPrefix(def, `"`); ok {
+		var schema strings.Builder
+		for {
+			i := strings.Index(rest, `"`)
+			if i < 0 {
+				return schema.String() + rest
+			}
+			schema.WriteString(rest[:i])
+			if !strings.HasPrefix(rest[i+1:], `"`) {
+				return schema.String()
+			}
+			schema.WriteString(`"`)
+			rest = rest[i+2:]
+		}
+	}
+	schema, _, _ := strings.Cut(def, ".")
+	return schema
+}
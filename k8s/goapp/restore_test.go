@@ -0,0 +1,127 @@
+package goapp
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"lesiw.io/command/mock"
+)
+
+func TestChooseBackup(t *testing.T) {
+	names := []string{
+		"app-20260101T000000Z.sql.gz",
+		"app-20260101T000000Z.sql.gz.sha256",
+		"app-20260301T000000Z.sql.gz",
+		"app-20260301T000000Z.sql.gz.sha256",
+		"app-20260401T000000Z.sql.gz", // Incomplete.
+		"other-20260501T000000Z.sql.gz",
+		"other-20260501T000000Z.sql.gz.sha256",
+		"app-staging-20260601T000000Z.sql.gz",
+		"app-staging-20260601T000000Z.sql.gz.sha256",
+	}
+
+	got, err := chooseBackup(names, "app", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "app-20260301T000000Z.sql.gz"; got != want {
+		t.Errorf("latest backup = %q, want %q", got, want)
+	}
+
+	got, err = chooseBackup(names, "app", "20260101T000000Z")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "app-20260101T000000Z.sql.gz"; got != want {
+		t.Errorf("chosen backup = %q, want %q", got, want)
+	}
+
+	if _, err := chooseBackup(names, "app", "20260401T000000Z"); err == nil {
+		t.Error("chooseBackup() should skip incomplete backups")
+	}
+}
+
+func TestFetchBackupChecksum(t *testing.T) {
+	ctx := context.Background()
+	store := memStore{}
+	name := "app-20260102T030405Z.sql.gz"
+	err := writeBackup(ctx, store, name, strings.NewReader("SELECT 1;"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := fetchBackup(ctx, store, name, &buf); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf.Bytes(), store[name]) {
+		t.Error("fetched backup does not match stored backup")
+	}
+
+	store[name] = append(store[name], 0)
+	err = fetchBackup(ctx, store, name, io.Discard)
+	if err == nil || !strings.Contains(err.Error(), "checksum mismatch") {
+		t.Errorf("fetchBackup() = %v, want checksum mismatch", err)
+	}
+}
+
+func TestTableCounter(t *testing.T) {
+	dump := "SET x = 1;\nCREATE TABLE public.a (\n    id int\n);\n" +
+		"CREATE TABLE public.b (\n    id int\n);\nCREATE INDEX i;\n" +
+		"CREATE TABLE \"My \"\"Schema\"\"\".c (\n    id int\n);\n"
+	c := &tableCounter{}
+	for chunk := range slices.Chunk([]byte(dump), 7) {
+		if _, err := c.Write(chunk); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want := map[string]int{"public": 2, `My "Schema"`: 1}
+	if !cmp.Equal(want, c.tables) {
+		t.Errorf("tables: -want +got\n%s", cmp.Diff(want, c.tables))
+	}
+}
+
+func TestScaleToZero(t *testing.T) {
+	ctx := context.Background()
+	m := new(mock.Machine)
+
+	if err := scale(ctx, m, "deployment/app", "0"); err != nil {
+		t.Fatal(err)
+	}
+	if calls := mock.Calls(m, "wait"); len(calls) > 0 {
+		t.Errorf("waited with no pods: %v", calls)
+	}
+
+	m.Return(strings.NewReader("pod/app-0\npod/app-1\n"), "get", "pod")
+	if err := scale(ctx, m, "deployment/app", "0"); err != nil {
+		t.Fatal(err)
+	}
+	got := mock.Calls(m, "wait")
+	want := []mock.Call{{Args: []string{"wait", "--for=delete",
+		"pod/app-0", "pod/app-1", "--timeout=5m"}}}
+	if !cmp.Equal(want, got) {
+		t.Errorf("wait calls: -want +got\n%s", cmp.Diff(want, got))
+	}
+}
+
+func TestVerifyRestore(t *testing.T) {
+	ctx := context.Background()
+	m := new(mock.Machine)
+	// PostGIS's spatial_ref_sys is left out by the query itself.
+	m.Return(strings.NewReader("public|2\nmetrics|1\n"), "psql")
+	want := map[string]int{"public": 2}
+
+	if err := verifyRestore(ctx, m, "app", want); err != nil {
+		t.Errorf("verifyRestore() err: %v", err)
+	}
+	want["audit"] = 1
+	if err := verifyRestore(ctx, m, "app", want); err == nil {
+		t.Error("verifyRestore() should fail on a missing schema")
+	}
+}
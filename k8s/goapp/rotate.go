@@ -0,0 +1,212 @@
+package goapp
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"time"
+
+	"labs.lesiw.io/ops/golang"
+	"lesiw.io/command"
+	"lesiw.io/command/sub"
+)
+
+// RotateSecrets gives the application a new Postgres password, if it
+// uses Postgres, and copies its EnvSecrets from spkez again. Its pods are
+// then restarted one at a time to pick up the new secrets.
+//
+// Each rotation is recorded in the <name>-secret-rotations ConfigMap,
+// keyed by when it happened, along with whether the restart succeeded.
+// A rotation is recorded even if the restart fails, since the secrets
+// have changed by then.
+func (op Ops) RotateSecrets(ctx context.Context) error {
+	op, err := op.environ(ctx)
+	if err != nil {
+		return err
+	}
+	kubectl, err := op.kubectl()
+	if err != nil {
+		return err
+	}
+	var rec rotation
+	if op.Postgres {
+		if err := op.rotatePostgresPassword(ctx, kubectl); err != nil {
+			return err
+		}
+		rec.Rotated = append(rec.Rotated, op.name()+"-db-secret")
+	}
+	err = op.restartRotated(ctx, kubectl, &rec)
+	if len(rec.Rotated) == 0 && len(rec.Resynced) == 0 {
+		if err == nil {
+			fmt.Println("No secrets to rotate.")
+		}
+		return err
+	}
+	rec.Restart = "succeeded"
+	if err != nil {
+		rec.Restart, _, _ = strings.Cut(err.Error(), "\n")
+	}
+	if rerr := op.recordRotation(ctx, kubectl, rec); rerr != nil {
+		return errors.Join(err, rerr)
+	}
+	return err
+}
+
+// restartRotated copies EnvSecrets from spkez again, adding them to rec,
+// then restarts the application's pods if any secret in rec changed.
+func (op Ops) restartRotated(
+	ctx context.Context, kubectl command.Machine, rec *rotation,
+) error {
+	if err := op.writeEnvSecrets(ctx); err != nil {
+		return err
+	}
+	for _, v := range slices.Sorted(maps.Values(op.EnvSecrets)) {
+		rec.Resynced = append(rec.Resynced, secretName(v))
+	}
+	if len(rec.Rotated) == 0 && len(rec.Resynced) == 0 {
+		return nil
+	}
+	workload := op.workload() + "/" + op.name()
+	err := golang.Mutate(ctx, kubectl, "rollout", "restart", workload)
+	if err != nil {
+		return fmt.Errorf("could not restart %s: %w", workload, err)
+	}
+	if golang.IsDryRun(ctx) {
+		return nil
+	}
+	if err := op.checkRollout(ctx, kubectl); err != nil {
+		return fmt.Errorf("restart after rotation failed: %w\n%s",
+			err, diagnostics(ctx, kubectl, op.name()))
+	}
+	return nil
+}
+
+// rotatePostgresPassword sets a new password for the application's
+// Postgres role and stores it in the <name>-db-secret Secret.
+//
+// The Secret is updated before the role, so a password that Postgres
+// accepts is never lost. If the role cannot be changed, the old password
+// is put back in the Secret.
+func (op Ops) rotatePostgresPassword(
+	ctx context.Context, kubectl command.Machine,
+) error {
+	name := op.name()
+	secretName := name + "-db-secret"
+	old, err := readSecret(ctx, kubectl, secretName)
+	if err != nil {
+		return fmt.Errorf("could not get postgres password: %w", err)
+	}
+	if old.weak() {
+		fmt.Printf("Replacing the weak password in %s.\n", secretName)
+	}
+	password, err := op.newSecret()
+	if err != nil {
+		return err
+	}
+	err = storeSecret(ctx, kubectl, secretName, password, generated)
+	if err != nil {
+		return fmt.Errorf("could not store postgres password: %w", err)
+	}
+	shown := password
+	if golang.IsDryRun(ctx) {
+		// Keep the password out of the printed command.
+		shown = "********"
+	}
+	pg := sub.Machine(op.postgres(kubectl), "psql", "-c")
+	err = golang.Mutate(ctx, pg, fmt.Sprintf("ALTER ROLE %s PASSWORD %s;",
+		quoteIdent(name), quoteLiteral(shown)))
+	if err != nil {
+		err = fmt.Errorf("could not change postgres password: %w", err)
+		var annotations map[string]string
+		if old.generator != "" {
+			annotations = map[string]string{
+				generatorAnnotation: old.generator,
+			}
+		}
+		rerr := storeSecret(ctx, kubectl,
+			secretName, old.password, annotations)
+		if rerr != nil {
+			err = errors.Join(err, fmt.Errorf("could not restore "+
+				"%s; rotate again to recover: %w", secretName, rerr))
+		}
+		return err
+	}
+	return nil
+}
+
+// storeSecret applies the Secret name holding password under "secret".
+func storeSecret(
+	ctx context.Context, kubectl command.Machine,
+	name, password string, annotations map[string]string,
+) error {
+	manifest, err := secretManifest(name, "secret", password, annotations)
+	if err != nil {
+		return err
+	}
+	return golang.MutateFrom(ctx, kubectl,
+		strings.NewReader(manifest), "apply", "-f", "-")
+}
+
+// A rotation is a record of a RotateSecrets run.
+type rotation struct {
+	Rotated  []string `json:"rotated,omitempty"`  // Given new values.
+	Resynced []string `json:"resynced,omitempty"` // Copied from spkez.
+	Restart  string   `json:"restart"`            // "succeeded", or the error.
+	By       string   `json:"by,omitempty"`
+}
+
+// recordRotation adds rec to the application's <name>-secret-rotations
+// ConfigMap, creating it if it is missing.
+func (op Ops) recordRotation(
+	ctx context.Context, kubectl command.Machine, rec rotation,
+) error {
+	by, err := golang.Local.Read(ctx, "git", "config", "user.email")
+	if err != nil || by == "" {
+		by = golang.Local.Env(ctx, "USER")
+	}
+	rec.By = by
+	record, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	name := op.name() + "-secret-rotations"
+	key := time.Now().UTC().Format("20060102T150405.000000000Z")
+	found, err := command.Read(ctx, kubectl, "get", "configmap", name,
+		"--ignore-not-found", "-o", "name")
+	if err != nil {
+		return fmt.Errorf("could not get %s: %w", name, err)
+	}
+	if found == "" {
+		manifest, err := marshalDocs(configMap{
+			APIVersion: "v1",
+			Kind:       "ConfigMap",
+			Metadata:   objectMeta{Name: name},
+			Data:       map[string]string{key: string(record)},
+		})
+		if err != nil {
+			return err
+		}
+		err = golang.MutateFrom(ctx, kubectl,
+			strings.NewReader(manifest), "create", "-f", "-")
+		if err != nil {
+			return fmt.Errorf("could not record rotation: %w", err)
+		}
+		return nil
+	}
+	patch, err := json.Marshal(map[string]any{
+		"data": map[string]string{key: string(record)},
+	})
+	if err != nil {
+		return err
+	}
+	err = golang.Mutate(ctx, kubectl, "patch", "configmap", name,
+		"--type=merge", "-p", string(patch))
+	if err != nil {
+		return fmt.Errorf("could not record rotation: %w", err)
+	}
+	return nil
+}
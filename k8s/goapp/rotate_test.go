@@ -0,0 +1,147 @@
+package goapp
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"lesiw.io/command"
+	"lesiw.io/command/mock"
+
+	"labs.lesiw.io/ops/goapp"
+)
+
+func TestRotateSecrets(t *testing.T) {
+	swap(t, &goapp.Name, "app")
+	m := setupCluster(t)
+	m.Return(secretJSON(t, "old-password", "crypto-rand"),
+		"kubectl", "get", "secret")
+	spkez := new(mock.Machine)
+	spkez.Return(strings.NewReader("s3cret"), "get")
+	swap(t, &getSpkez, func() (command.Machine, error) {
+		return spkez, nil
+	})
+	op := Ops{
+		Postgres:   true,
+		EnvSecrets: map[string]string{"API_KEY": "app/api"},
+	}
+
+	if err := op.RotateSecrets(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	for _, c := range mock.Calls(m, "kubectl") {
+		got = append(got, strings.Join(c.Args[1:], " "))
+	}
+	want := []string{
+		"get secret app-db-secret -o json",
+		"apply -f -",
+		"exec postgres-1 -c postgres -- psql -c ALTER ROLE \"app\" PASSWORD",
+		"apply -f -",
+		"rollout restart statefulset/app",
+		"rollout status statefulset/app",
+		"get configmap app-secret-rotations",
+		"create -f -",
+	}
+	if len(got) != len(want) {
+		t.Fatalf("kubectl calls = %q, want %q", got, want)
+	}
+	for i := range want {
+		if !strings.HasPrefix(got[i], want[i]) {
+			t.Errorf("kubectl call %d = %q, want %q", i, got[i], want[i])
+		}
+	}
+	if strings.Contains(got[2], "PASSWORD '';") {
+		t.Errorf("rotated to an empty password: %q", got[2])
+	}
+}
+
+func TestRotateSecretsRecord(t *testing.T) {
+	swap(t, &goapp.Name, "app")
+	m := setupCluster(t)
+	m.Return(secretJSON(t, "old-password", "crypto-rand"),
+		"kubectl", "get", "secret")
+	m.Return(strings.NewReader("configmap/app-secret-rotations\n"),
+		"kubectl", "get", "configmap")
+	op := Ops{Postgres: true}
+
+	if err := op.RotateSecrets(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	patch := mock.Calls(m, "kubectl", "patch", "configmap")
+	if len(patch) != 1 {
+		t.Fatalf("patch calls = %v, want 1", patch)
+	}
+	args := strings.Join(patch[0].Args, " ")
+	if !strings.Contains(args, "app-secret-rotations --type=merge") ||
+		!strings.Contains(args, `\"rotated\":[\"app-db-secret\"]`) ||
+		!strings.Contains(args, `\"restart\":\"succeeded\"`) {
+		t.Errorf("patch = %q, want a record of app-db-secret", args)
+	}
+	if calls := mock.Calls(m, "kubectl", "create"); len(calls) > 0 {
+		t.Errorf("unexpected create calls: %v", calls)
+	}
+}
+
+func TestRotateSecretsRestartFails(t *testing.T) {
+	swap(t, &goapp.Name, "app")
+	m := setupCluster(t)
+	m.Return(secretJSON(t, "old-password", "crypto-rand"),
+		"kubectl", "get", "secret")
+	m.Return(command.Fail(&command.Error{Err: errors.New("timed out")}),
+		"kubectl", "rollout", "status")
+	op := Ops{Postgres: true}
+
+	err := op.RotateSecrets(context.Background())
+	if err == nil {
+		t.Fatal("RotateSecrets() should fail when the restart fails")
+	}
+
+	create := mock.Calls(m, "kubectl", "create")
+	if len(create) != 1 {
+		t.Fatalf("create calls = %v, want 1", create)
+	}
+	record := string(create[0].Got)
+	if !strings.Contains(record, `"rotated":["app-db-secret"]`) ||
+		!strings.Contains(record, `"restart":"restart after rotation failed`) {
+		t.Errorf("record = %s, want a failed rotation of app-db-secret",
+			record)
+	}
+}
+
+func TestRotatePostgresPasswordRestores(t *testing.T) {
+	swap(t, &goapp.Name, "app")
+	m := setupCluster(t)
+	m.Return(secretJSON(t, "old-password", ""), "kubectl", "get", "secret")
+	m.Return(command.Fail(&command.Error{Err: errors.New("no role")}),
+		"kubectl", "exec")
+	kubectl, err := Ops{}.kubectl()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = Ops{}.rotatePostgresPassword(context.Background(), kubectl)
+	if err == nil {
+		t.Fatal("rotatePostgresPassword() should fail when ALTER fails")
+	}
+
+	apply := mock.Calls(m, "kubectl", "apply")
+	if len(apply) != 2 {
+		t.Fatalf("got %d applies, want 2", len(apply))
+	}
+	if strings.Contains(string(apply[0].Got), "old-password") {
+		t.Errorf("first apply kept the old password:\n%s", apply[0].Got)
+	}
+	if !strings.Contains(string(apply[0].Got), generatorAnnotation) {
+		t.Errorf("first apply = %s, want it marked generated", apply[0].Got)
+	}
+	restored := string(apply[1].Got)
+	if !strings.Contains(restored, "secret: old-password") ||
+		strings.Contains(restored, generatorAnnotation) {
+		t.Errorf("second apply = %s, want the unmarked old password",
+			restored)
+	}
+}
@@ -0,0 +1,259 @@
+package goapp
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"debug/buildinfo"
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"path"
+	"regexp"
+	"slices"
+	"strings"
+
+	"golang.org/x/mod/semver"
+
+	"labs.lesiw.io/ops/golang"
+	"lesiw.io/command"
+)
+
+// A finding is a problem that the pre-push scan found in an image.
+type finding struct {
+	severity string // CRITICAL, HIGH, MODERATE, LOW or UNKNOWN.
+	what     string
+}
+
+func (f finding) String() string { return f.severity + ": " + f.what }
+
+// blocks reports whether f stops images from being pushed.
+// Vulnerabilities without a severity, like those in the Go
+// vulnerability database, are treated as high.
+func (f finding) blocks() bool {
+	switch f.severity {
+	case "CRITICAL", "HIGH", "UNKNOWN":
+		return true
+	}
+	return false
+}
+
+// secretNames match the base names of files that hold credentials.
+var secretNames = regexp.MustCompile(`^(id_(rsa|dsa|ecdsa|ed25519)|` +
+	`.*\.(pem|key|p12|pfx|kubeconfig)|\.env(\..*)?|\.netrc|\.npmrc|` +
+	`\.git-credentials|credentials|kubeconfig)$`)
+
+// secretPatterns match key-shaped strings.
+var secretPatterns = map[string]*regexp.Regexp{
+	"private key": regexp.MustCompile(
+		`-----BEGIN [A-Z ]*PRIVATE KEY-----\s*[A-Za-z0-9+/=\s]{64}`),
+	"AWS access key":  regexp.MustCompile(`\bAKIA[0-9A-Z]{16}\b`),
+	"GitHub token":    regexp.MustCompile(`\bgh[opsru]_[A-Za-z0-9]{36}\b`),
+	"Slack token":     regexp.MustCompile(`\bxox[abprs]-[A-Za-z0-9-]{10,}`),
+	"Stripe live key": regexp.MustCompile(`\b[rs]k_live_[A-Za-z0-9]{24,}`),
+	"GCP service key": regexp.MustCompile(`"type":\s*"service_account"`),
+	"JSON web token":  regexp.MustCompile(`\beyJhbGciOi[A-Za-z0-9_-]{20,}\.`),
+}
+
+// scan inspects the files that imgs add to their base images for
+// bundled secrets, and the application's build info for known
+// vulnerabilities in the OSV entries under VulnDB.
+func (op Ops) scan(
+	ctx context.Context, sh *command.Sh, imgs []ociImage,
+) ([]finding, error) {
+	var vulns []osvEntry
+	if op.VulnDB != "" {
+		var err error
+		if vulns, err = loadVulnDB(ctx, sh, op.VulnDB); err != nil {
+			return nil, fmt.Errorf(
+				"could not load vulnerability database: %w", err)
+		}
+	}
+	var findings []finding
+	add := func(f finding) {
+		if !slices.Contains(findings, f) {
+			findings = append(findings, f)
+		}
+	}
+	for _, src := range slices.Sorted(maps.Keys(op.Image.Files)) {
+		if p := path.Clean(src); p == "out" || strings.HasPrefix(p, "out/") {
+			add(finding{"HIGH", "build output " + src + " is bundled"})
+		}
+	}
+	for _, img := range imgs {
+		for _, f := range img.files {
+			for _, s := range scanSecrets(f) {
+				add(s)
+			}
+			if f.name == "app" {
+				for _, v := range scanVulns(f.data, vulns) {
+					add(v)
+				}
+			}
+		}
+	}
+	return findings, nil
+}
+
+// checkScan prints findings and returns an error if any of them block
+// pushing images, unless SCAN_OVERRIDE is set in the environment.
+func checkScan(ctx context.Context, w io.Writer, findings []finding) error {
+	var blocking int
+	for _, f := range findings {
+		if _, err := fmt.Fprintln(w, "scan:", f); err != nil {
+			return err
+		}
+		if f.blocks() {
+			blocking++
+		}
+	}
+	if blocking == 0 {
+		return nil
+	}
+	if golang.Local.Env(ctx, "SCAN_OVERRIDE") != "" {
+		_, err := fmt.Fprintf(w,
+			"scan: pushing despite %d blocking findings\n", blocking)
+		return err
+	}
+	return fmt.Errorf("image scan found %d blocking findings; "+
+		"set SCAN_OVERRIDE to push anyway", blocking)
+}
+
+func scanSecrets(f layerFile) []finding {
+	var findings []finding
+	if f.mode.IsDir() {
+		return nil
+	}
+	if secretNames.MatchString(path.Base(f.name)) {
+		findings = append(findings,
+			finding{"HIGH", "/" + f.name + " looks like a credentials file"})
+	}
+	for _, kind := range slices.Sorted(maps.Keys(secretPatterns)) {
+		if secretPatterns[kind].Match(f.data) {
+			findings = append(findings,
+				finding{"HIGH", "/" + f.name + " contains a " + kind})
+		}
+	}
+	return findings
+}
+
+// scanVulns checks the modules and Go version in the build info of the
+// binary bin against vulns.
+func scanVulns(bin []byte, vulns []osvEntry) []finding {
+	if len(vulns) == 0 {
+		return nil
+	}
+	info, err := buildinfo.Read(bytes.NewReader(bin))
+	if err != nil {
+		return nil // Not a Go binary.
+	}
+	goVersion, _, _ := strings.Cut(info.GoVersion, " ")
+	versions := map[string]string{
+		"stdlib": "v" + strings.TrimPrefix(goVersion, "go"),
+	}
+	for _, dep := range info.Deps {
+		if dep.Replace != nil {
+			dep = dep.Replace
+		}
+		versions[dep.Path] = dep.Version
+	}
+	var findings []finding
+	for _, v := range vulns {
+		for _, a := range v.Affected {
+			version, ok := versions[a.Package.Name]
+			if !ok || a.Package.Ecosystem != "Go" || !a.affects(version) {
+				continue
+			}
+			findings = append(findings, finding{
+				severity: cmp.Or(strings.ToUpper(
+					v.DatabaseSpecific.Severity), "UNKNOWN"),
+				what: fmt.Sprintf("%s %s: %s %s", a.Package.Name, version,
+					v.ID, v.Summary),
+			})
+		}
+	}
+	return findings
+}
+
+// An osvEntry is a vulnerability in the OSV format.
+type osvEntry struct {
+	ID               string        `json:"id"`
+	Summary          string        `json:"summary"`
+	Affected         []osvAffected `json:"affected"`
+	DatabaseSpecific struct {
+		Severity string `json:"severity"`
+	} `json:"database_specific"`
+}
+
+type osvAffected struct {
+	Package struct {
+		Ecosystem string `json:"ecosystem"`
+		Name      string `json:"name"`
+	} `json:"package"`
+	Ranges []struct {
+		Type   string              `json:"type"`
+		Events []map[string]string `json:"events"`
+	} `json:"ranges"`
+	Versions []string `json:"versions"`
+}
+
+// affects reports whether a affects version v of its package.
+func (a osvAffected) affects(v string) bool {
+	if !semver.IsValid(v) {
+		return false
+	}
+	if slices.Contains(a.Versions, strings.TrimPrefix(v, "v")) {
+		return true
+	}
+	for _, r := range a.Ranges {
+		if r.Type != "SEMVER" {
+			continue
+		}
+		var affected bool
+		for _, e := range r.Events {
+			if intro, ok := e["introduced"]; ok {
+				if intro == "0" || semver.Compare(v, "v"+intro) >= 0 {
+					affected = true
+				}
+			} else if fixed, ok := e["fixed"]; ok {
+				if semver.Compare(v, "v"+fixed) >= 0 {
+					affected = false
+				}
+			} else if last, ok := e["last_affected"]; ok {
+				if semver.Compare(v, "v"+last) > 0 {
+					affected = false
+				}
+			}
+		}
+		if affected {
+			return true
+		}
+	}
+	return false
+}
+
+// loadVulnDB reads the OSV entries in the JSON files under dir.
+func loadVulnDB(
+	ctx context.Context, sh *command.Sh, dir string,
+) ([]osvEntry, error) {
+	var entries []osvEntry
+	for e, err := range sh.Walk(ctx, dir, 0) {
+		if err != nil {
+			return nil, err
+		}
+		if e.IsDir() || path.Ext(e.Name()) != ".json" {
+			continue
+		}
+		buf, err := sh.ReadFile(ctx, e.Path())
+		if err != nil {
+			return nil, err
+		}
+		var entry osvEntry
+		if json.Unmarshal(buf, &entry) != nil || entry.ID == "" {
+			continue // An index or other metadata.
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
@@ -0,0 +1,139 @@
+package goapp
+
+import (
+	"cmp"
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/big"
+	"slices"
+	"strings"
+	"unicode"
+
+	"lesiw.io/command"
+)
+
+const (
+	defaultSecretLength   = 32
+	defaultSecretAlphabet = "abcdefghijklmnopqrstuvwxyz" +
+		"ABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+
+	// minSecretBits is the least entropy a secret should have.
+	minSecretBits = 128
+
+	// generatorAnnotation marks Secrets holding a value from newSecret.
+	// Passwords from the math/rand generator that came before it look
+	// as strong as its own, so only this marker tells them apart.
+	generatorAnnotation = "labs.lesiw.io/secret-generator"
+)
+
+// generated holds the annotations of Secrets made by newSecret.
+var generated = map[string]string{generatorAnnotation: "crypto-rand"}
+
+// newSecret returns a random secret of SecretLength characters from
+// SecretAlphabet.
+func (op Ops) newSecret() (string, error) {
+	return randSecret(
+		cmp.Or(op.SecretLength, defaultSecretLength),
+		cmp.Or(op.SecretAlphabet, defaultSecretAlphabet),
+	)
+}
+
+// randSecret returns n characters chosen uniformly from alphabet with
+// crypto/rand. It fails if such secrets have less than minSecretBits
+// of entropy.
+func randSecret(n int, alphabet string) (string, error) {
+	chars := []rune(alphabet)
+	slices.Sort(chars)
+	chars = slices.Compact(chars)
+	bits := float64(n) * math.Log2(float64(len(chars)))
+	if bits < minSecretBits {
+		return "", fmt.Errorf("secrets of %d characters from %d symbols "+
+			"have %.0f bits of entropy, want at least %d",
+			n, len(chars), bits, minSecretBits)
+	}
+	var b strings.Builder
+	size := big.NewInt(int64(len(chars)))
+	for range n {
+		i, err := rand.Int(rand.Reader, size)
+		if err != nil {
+			return "", fmt.Errorf("could not generate secret: %w", err)
+		}
+		b.WriteRune(chars[i.Int64()])
+	}
+	return b.String(), nil
+}
+
+// weakSecret reports whether s looks like it has less than minSecretBits
+// of entropy, judging by its length and the kinds of characters in it.
+func weakSecret(s string) bool {
+	var lower, upper, digit, other bool
+	for _, r := range s {
+		switch {
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsDigit(r):
+			digit = true
+		default:
+			other = true
+		}
+	}
+	var symbols int
+	for _, kind := range []struct {
+		seen bool
+		n    int
+	}{{lower, 26}, {upper, 26}, {digit, 10}, {other, 33}} {
+		if kind.seen {
+			symbols += kind.n
+		}
+	}
+	if symbols == 0 {
+		return true
+	}
+	n := len([]rune(s))
+	return float64(n)*math.Log2(float64(symbols)) < minSecretBits
+}
+
+// A storedSecret is a password read back from a Kubernetes Secret.
+type storedSecret struct {
+	password  string
+	generator string // Its generatorAnnotation, if any.
+}
+
+// readSecret returns the password under the "secret" key of the Secret
+// name.
+func readSecret(
+	ctx context.Context, kubectl command.Machine, name string,
+) (storedSecret, error) {
+	out, err := command.Read(ctx, kubectl,
+		"get", "secret", name, "-o", "json")
+	if err != nil {
+		return storedSecret{}, fmt.Errorf("could not get %s: %w", name, err)
+	}
+	var s struct {
+		Metadata struct {
+			Annotations map[string]string `json:"annotations"`
+		} `json:"metadata"`
+		Data struct {
+			Secret []byte `json:"secret"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal([]byte(out), &s); err != nil {
+		return storedSecret{}, fmt.Errorf("could not decode %s: %w",
+			name, err)
+	}
+	return storedSecret{
+		password:  string(s.Data.Secret),
+		generator: s.Metadata.Annotations[generatorAnnotation],
+	}, nil
+}
+
+// weak reports whether s should be rotated: either it was not made by
+// newSecret, or it looks like it has too little entropy.
+func (s storedSecret) weak() bool {
+	return s.generator == "" || weakSecret(s.password)
+}
@@ -0,0 +1,111 @@
+package goapp
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"labs.lesiw.io/ops/goapp"
+)
+
+// secretJSON returns a Secret holding password as kubectl prints it,
+// with a generatorAnnotation of generator unless that is empty.
+func secretJSON(t *testing.T, password, generator string) *strings.Reader {
+	t.Helper()
+	annotations := map[string]string{}
+	if generator != "" {
+		annotations[generatorAnnotation] = generator
+	}
+	b, err := json.Marshal(map[string]any{
+		"metadata": map[string]any{"annotations": annotations},
+		"data":     map[string][]byte{"secret": []byte(password)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.NewReader(string(b))
+}
+
+func TestNewSecret(t *testing.T) {
+	op := Ops{SecretLength: 64, SecretAlphabet: "0123456789abcdef"}
+
+	a, err := op.newSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := op.newSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(a) != 64 || strings.Trim(a, "0123456789abcdef") != "" {
+		t.Errorf("newSecret() = %q, want 64 hex digits", a)
+	}
+	if a == b {
+		t.Errorf("newSecret() returned %q twice", a)
+	}
+	if s, err := (Ops{}).newSecret(); err != nil || len(s) != 32 {
+		t.Errorf("newSecret() = %q, %v, want 32 characters", s, err)
+	}
+	if _, err := (Ops{SecretLength: 16}).newSecret(); err == nil {
+		t.Error("newSecret() of 16 characters should fail")
+	}
+	op = Ops{SecretLength: 200, SecretAlphabet: "aaaa"}
+	if _, err := op.newSecret(); err == nil {
+		t.Error("newSecret() from one symbol should fail")
+	}
+}
+
+func TestWeakSecret(t *testing.T) {
+	tests := []struct {
+		s    string
+		want bool
+	}{
+		{"", true},
+		{"hunter2", true},
+		{strings.Repeat("a", 27), true},
+		{strings.Repeat("a", 28), false},
+		{"Xq7dLmP2vR9tKc4wNb8zHf3sJg6yTe5u", false},
+	}
+	for _, tt := range tests {
+		if got := weakSecret(tt.s); got != tt.want {
+			t.Errorf("weakSecret(%q) = %t, want %t", tt.s, got, tt.want)
+		}
+	}
+}
+
+func TestReadSecret(t *testing.T) {
+	swap(t, &goapp.Name, "app")
+	m := setupCluster(t)
+	kubectl, err := Ops{}.kubectl()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Passwords from the old math/rand generator look like this one.
+	const old = "Xq7dLmP2vR9tKc4wNb8zHf3sJg6yTe5u"
+	tests := []struct {
+		generator string
+		weak      bool
+	}{
+		{"", true},
+		{"crypto-rand", false},
+	}
+	for _, tt := range tests {
+		m.Return(secretJSON(t, old, tt.generator),
+			"kubectl", "get", "secret", "app-db-secret")
+
+		s, err := readSecret(context.Background(), kubectl, "app-db-secret")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if s.password != old || s.generator != tt.generator {
+			t.Errorf("readSecret() = %+v, want %q from %q",
+				s, old, tt.generator)
+		}
+		if got := s.weak(); got != tt.weak {
+			t.Errorf("%+v weak() = %t, want %t", s, got, tt.weak)
+		}
+	}
+}
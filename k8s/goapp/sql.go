@@ -0,0 +1,33 @@
+package goapp
+
+import (
+	"strconv"
+	"strings"
+)
+
+// quoteIdent quotes s for use as a Postgres identifier, such as a role.
+// Quoted identifiers keep their case, and may contain hyphens.
+func quoteIdent(s string) string {
+	return `"` + strings.ReplaceAll(s, `"`, `""`) + `"`
+}
+
+// quoteLiteral quotes s for use as a Postgres string literal.
+// Like Postgres's own quote_literal, it uses an escape string if s
+// contains backslashes, whatever standard_conforming_strings is set to.
+func quoteLiteral(s string) string {
+	s = strings.ReplaceAll(s, `'`, `''`)
+	if !strings.Contains(s, `\`) {
+		return "'" + s + "'"
+	}
+	return "E'" + strings.ReplaceAll(s, `\`, `\\`) + "'"
+}
+
+// dollarQuote quotes body as a dollar-quoted string constant, with a tag
+// that does not occur in body.
+func dollarQuote(body string) string {
+	tag := "$do$"
+	for i := 1; strings.Contains(body, tag); i++ {
+		tag = "$do" + strconv.Itoa(i) + "$"
+	}
+	return tag + "\n" + body + tag
+}
@@ -0,0 +1,84 @@
+package goapp
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"lesiw.io/command/mock"
+
+	"labs.lesiw.io/ops/goapp"
+)
+
+func TestQuoteIdent(t *testing.T) {
+	tests := []struct{ in, want string }{
+		{"app", `"app"`},
+		{"app-staging", `"app-staging"`},
+		{`a"b`, `"a""b"`},
+	}
+	for _, tt := range tests {
+		if got := quoteIdent(tt.in); got != tt.want {
+			t.Errorf("quoteIdent(%q) = %s, want %s", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestQuoteLiteral(t *testing.T) {
+	tests := []struct{ in, want string }{
+		{"s3cret", `'s3cret'`},
+		{"it's", `'it''s'`},
+		{`a\'b`, `E'a\\''b'`},
+	}
+	for _, tt := range tests {
+		if got := quoteLiteral(tt.in); got != tt.want {
+			t.Errorf("quoteLiteral(%q) = %s, want %s", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestDollarQuote(t *testing.T) {
+	tests := []struct{ in, want string }{
+		{"BEGIN END", "$do$\nBEGIN END$do$"},
+		{"SELECT '$do$';", "$do1$\nSELECT '$do$';$do1$"},
+	}
+	for _, tt := range tests {
+		if got := dollarQuote(tt.in); got != tt.want {
+			t.Errorf("dollarQuote(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestCreatePostgresRole(t *testing.T) {
+	swap(t, &goapp.Name, "app")
+	m := setupCluster(t)
+	m.Return(secretJSON(t, "it's-a-long-enough-pass\\phrase", "crypto-rand"),
+		"kubectl", "get", "secret")
+	op := Ops{Postgres: true, Environments: map[string]Environment{
+		"staging": {Suffix: "staging"},
+	}}
+	t.Setenv("ENVIRONMENT", "staging")
+	ctx := context.Background()
+	op, err := op.environ(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := op.createPostgresRole(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	calls := mock.Calls(m, "kubectl", "exec")
+	if len(calls) != 1 {
+		t.Fatalf("exec calls = %v, want 1", calls)
+	}
+	sql := calls[0].Args[len(calls[0].Args)-1]
+	for _, want := range []string{
+		`WHERE rolname = 'app-staging'`,
+		`CREATE ROLE "app-staging" LOGIN PASSWORD ` +
+			`E'it''s-a-long-enough-pass\\phrase'`,
+	} {
+		if !strings.Contains(sql, want) {
+			t.Errorf("sql does not contain %s:\n%s", want, sql)
+		}
+	}
+}
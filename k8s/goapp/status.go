@@ -0,0 +1,183 @@
+package goapp
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"labs.lesiw.io/ops/golang"
+	"lesiw.io/command"
+)
+
+// Status prints the state of the deployed application: its ready
+// replicas and image, its pods and their restarts, recent pod events,
+// and its ingress and certificate if it has a Hostname.
+func (op Ops) Status(ctx context.Context) error {
+	op, err := op.environ(ctx)
+	if err != nil {
+		return err
+	}
+	kubectl, err := op.kubectl()
+	if err != nil {
+		return err
+	}
+	return op.status(ctx, kubectl, os.Stdout)
+}
+
+// Logs prints the logs of all of the application's pods.
+// Set FOLLOW in the environment to stream new logs,
+// and SINCE to a duration such as 1h to only show recent logs.
+func (op Ops) Logs(ctx context.Context) error {
+	op, err := op.environ(ctx)
+	if err != nil {
+		return err
+	}
+	kubectl, err := op.kubectl()
+	if err != nil {
+		return err
+	}
+	args, err := logsArgs(ctx, op.name())
+	if err != nil {
+		return err
+	}
+	return command.Exec(ctx, kubectl, args...)
+}
+
+func logsArgs(ctx context.Context, name string) ([]string, error) {
+	args := []string{
+		"logs", "-l", "app=" + name,
+		"--all-containers", "--prefix", "--max-log-requests=20",
+	}
+	if golang.Local.Env(ctx, "FOLLOW") != "" {
+		args = append(args, "--follow")
+	}
+	if since := golang.Local.Env(ctx, "SINCE"); since != "" {
+		if _, err := time.ParseDuration(since); err != nil {
+			return nil, fmt.Errorf("bad SINCE %q: %w", since, err)
+		}
+		args = append(args, "--since="+since)
+	}
+	return args, nil
+}
+
+func (op Ops) status(
+	ctx context.Context, kubectl command.Machine, w io.Writer,
+) error {
+	kind := op.workload()
+	out, err := command.Read(ctx, kubectl,
+		"get", kind, op.name(), "-o", "json")
+	if err != nil {
+		return fmt.Errorf("could not get %s: %w", kind, err)
+	}
+	var workload struct {
+		Spec struct {
+			Replicas int
+			Template struct {
+				Spec struct {
+					Containers []struct{ Name, Image string }
+				}
+			}
+		}
+		Status struct{ ReadyReplicas int }
+	}
+	if err := json.Unmarshal([]byte(out), &workload); err != nil {
+		return fmt.Errorf("could not parse %s: %w", kind, err)
+	}
+	var buf strings.Builder
+	fmt.Fprintf(&buf, "%s/%s: %d/%d ready\n", kind, op.name(),
+		workload.Status.ReadyReplicas, workload.Spec.Replicas)
+	for _, c := range workload.Spec.Template.Spec.Containers {
+		fmt.Fprintf(&buf, "image: %s\n", c.Image)
+	}
+
+	out, err = command.Read(ctx, kubectl,
+		"get", "pods", "-l", "app="+op.name(), "-o", "json")
+	if err != nil {
+		return fmt.Errorf("could not get pods: %w", err)
+	}
+	var pods struct {
+		Items []struct {
+			Metadata struct{ Name string }
+			Status   struct {
+				Phase             string
+				ContainerStatuses []struct {
+					Ready        bool
+					RestartCount int
+				}
+			}
+		}
+	}
+	if err := json.Unmarshal([]byte(out), &pods); err != nil {
+		return fmt.Errorf("could not parse pods: %w", err)
+	}
+	buf.WriteString("\n")
+	tw := tabwriter.NewWriter(&buf, 0, 4, 2, ' ', 0)
+	if _, err := fmt.Fprintln(tw, "POD\tPHASE\tREADY\tRESTARTS"); err != nil {
+		return err
+	}
+	for _, pod := range pods.Items {
+		var ready, restarts int
+		cs := pod.Status.ContainerStatuses
+		for _, c := range cs {
+			if c.Ready {
+				ready++
+			}
+			restarts += c.RestartCount
+		}
+		if _, err := fmt.Fprintf(tw, "%s\t%s\t%d/%d\t%d\n",
+			pod.Metadata.Name, pod.Status.Phase, ready, len(cs), restarts,
+		); err != nil {
+			return err
+		}
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	buf.WriteString("\n")
+	buf.WriteString(podEvents(ctx, kubectl, op.name()))
+
+	if op.Hostname != "" {
+		buf.WriteString("\n")
+		buf.WriteString(op.ingressStatus(ctx, kubectl))
+	}
+	_, err = io.WriteString(w, buf.String())
+	return err
+}
+
+// ingressStatus describes the application's certificate and ingress.
+func (op Ops) ingressStatus(
+	ctx context.Context, kubectl command.Machine,
+) string {
+	var buf strings.Builder
+	ready, err := command.Read(ctx, kubectl,
+		"get", "certificate", op.Hostname, "-o",
+		`jsonpath={.status.conditions[?(@.type=="Ready")].status}`)
+	switch {
+	case err != nil:
+		fmt.Fprintf(&buf, "certificate/%s: %v\n", op.Hostname, err)
+	case ready == "True":
+		fmt.Fprintf(&buf, "certificate/%s: ready\n", op.Hostname)
+	default:
+		fmt.Fprintf(&buf, "certificate/%s: not ready\n", op.Hostname)
+	}
+	name := op.name() + "-ingress"
+	addrs, err := command.Read(ctx, kubectl,
+		"get", "ingress", name, "-o",
+		"jsonpath={.status.loadBalancer.ingress[*]['ip','hostname']}")
+	switch {
+	case err != nil:
+		fmt.Fprintf(&buf, "ingress/%s: %v\n", name, err)
+	case addrs == "":
+		fmt.Fprintf(&buf, "ingress/%s: no address\n", name)
+	default:
+		fmt.Fprintf(&buf, "ingress/%s: https://%s via %s\n",
+			name, op.Hostname, addrs)
+	}
+	return buf.String()
+}
@@ -0,0 +1,71 @@
+package goapp
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"testing"
+
+	"labs.lesiw.io/ops/goapp"
+	"lesiw.io/command/mock"
+)
+
+func TestStatus(t *testing.T) {
+	swap(t, &goapp.Name, "app")
+	m := new(mock.Machine)
+	m.Return(strings.NewReader(`{
+"spec": {"replicas": 2, "template": {"spec": {"containers": [
+	{"name": "app", "image": "ctr.lesiw.dev/app:100"}
+]}}},
+"status": {"readyReplicas": 1}
+}`), "get", "deployment", "app")
+	m.Return(strings.NewReader(`{"items": [
+{"metadata": {"name": "app-a"}, "status": {"phase": "Running",
+	"containerStatuses": [{"ready": true, "restartCount": 0}]}},
+{"metadata": {"name": "app-b"}, "status": {"phase": "Running",
+	"containerStatuses": [{"ready": false, "restartCount": 7}]}}
+]}`), "get", "pods")
+	m.Return(strings.NewReader("True"), "get", "certificate")
+	m.Return(strings.NewReader("10.0.0.1"), "get", "ingress")
+
+	var buf strings.Builder
+	op := Ops{Scalable: true, Hostname: "app.example.com"}
+	if err := op.status(context.Background(), m, &buf); err != nil {
+		t.Fatal(err)
+	}
+
+	got := buf.String()
+	for _, want := range []string{
+		"deployment/app: 1/2 ready",
+		"image: ctr.lesiw.dev/app:100",
+		"app-b  Running  0/1    7",
+		"certificate/app.example.com: ready",
+		"ingress/app-ingress: https://app.example.com via 10.0.0.1",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("status missing %q:\n%s", want, got)
+		}
+	}
+}
+
+func TestLogsArgs(t *testing.T) {
+	swap(t, &goapp.Name, "app")
+	t.Setenv("FOLLOW", "1")
+	t.Setenv("SINCE", "15m")
+
+	args, err := logsArgs(context.Background(), "app")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []string{"app=app", "--follow", "--since=15m"} {
+		if !slices.Contains(args, want) {
+			t.Errorf("args %q missing %q", args, want)
+		}
+	}
+
+	t.Setenv("SINCE", "yesterday")
+	if _, err := logsArgs(context.Background(), "app"); err == nil {
+		t.Error("logsArgs() should fail on a bad SINCE")
+	}
+}
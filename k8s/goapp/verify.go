@@ -0,0 +1,178 @@
+package goapp
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"labs.lesiw.io/ops/golang"
+	"lesiw.io/command"
+)
+
+// A SmokeTest is an HTTP request made through the application's Service
+// once it has rolled out.
+type SmokeTest struct {
+	Path   string // Path to GET. Defaults to "/".
+	Status int    // Expected status code. Defaults to any below 500.
+}
+
+// verify waits for the deployed release to roll out and pass its smoke
+// test. If it does not, the release is rolled back to its previous
+// revision, if it has one, and the returned error includes recent pod
+// events and logs.
+func (op Ops) verify(ctx context.Context) error {
+	if golang.IsDryRun(ctx) {
+		return nil
+	}
+	kubectl, err := op.kubectl()
+	if err != nil {
+		return err
+	}
+	err = op.checkRollout(ctx, kubectl)
+	if err == nil {
+		return nil
+	}
+	// Gather diagnostics before the rollback replaces the failed pods.
+	diag := diagnostics(ctx, kubectl, op.name())
+	rolledBack, herr := op.rollbackFailed(ctx)
+	switch {
+	case herr != nil:
+		err = errors.Join(err, fmt.Errorf("could not roll back: %w", herr))
+	case rolledBack:
+		err = fmt.Errorf("%w (rolled back to the previous revision)", err)
+	default:
+		err = fmt.Errorf("%w (not rolled back: no previous revision)", err)
+	}
+	return fmt.Errorf("deploy failed verification: %w\n%s", err, diag)
+}
+
+// checkRollout waits for the workload to roll out,
+// then runs the smoke test if the application has a Port.
+func (op Ops) checkRollout(
+	ctx context.Context, kubectl command.Machine,
+) error {
+	timeout := cmp.Or(op.RolloutTimeout, 5*time.Minute)
+	err := command.Exec(ctx, kubectl,
+		"rollout", "status", op.workload()+"/"+op.name(),
+		"--timeout="+timeout.String())
+	if err != nil {
+		return fmt.Errorf("rollout did not finish: %w", err)
+	}
+	if op.Port == 0 {
+		return nil
+	}
+	return op.smokeTest(ctx, kubectl)
+}
+
+// rollbackFailed rolls the release back to its previous revision,
+// if it has one. A first install has none, so it is left as it is.
+func (op Ops) rollbackFailed(ctx context.Context) (bool, error) {
+	helm, err := op.helm()
+	if err != nil {
+		return false, err
+	}
+	out, err := command.Read(ctx, helm,
+		"helm", "history", op.name(), "--max", "2", "-o", "json")
+	if err != nil {
+		return false, fmt.Errorf("could not get release history: %w", err)
+	}
+	var revs []json.RawMessage
+	if err := json.Unmarshal([]byte(out), &revs); err != nil {
+		return false, fmt.Errorf("could not parse release history: %w", err)
+	}
+	if len(revs) < 2 {
+		return false, nil
+	}
+	err = golang.Mutate(ctx, helm, "helm", "rollback", op.name(), "--wait")
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// smokeTest requests SmokeTest's path from the application's Service
+// from a short-lived pod in the cluster.
+func (op Ops) smokeTest(ctx context.Context, kubectl command.Machine) error {
+	var st SmokeTest
+	if op.SmokeTest != nil {
+		st = *op.SmokeTest
+	}
+	url := "http://" + op.name() + "/" +
+		strings.TrimPrefix(st.Path, "/")
+	out, err := command.Read(ctx, kubectl,
+		"run", op.name()+"-smoke-test", "--rm", "-i", "--quiet",
+		"--labels="+smokeTestLabel+"="+op.name(),
+		"--restart=Never", "--image=curlimages/curl", "--command", "--",
+		"curl", "-sS", "-o", "/dev/null", "-w", "%{http_code}",
+		"--max-time", "10", url,
+	)
+	if err != nil {
+		return fmt.Errorf("smoke test of %s failed: %w", url, err)
+	}
+	code, err := strconv.Atoi(strings.TrimSpace(out))
+	if err != nil {
+		return fmt.Errorf("smoke test of %s: bad status %q", url, out)
+	}
+	if st.Status != 0 && code != st.Status {
+		return fmt.Errorf("smoke test of %s: got status %d, want %d",
+			url, code, st.Status)
+	}
+	if st.Status == 0 && code >= 500 {
+		return fmt.Errorf("smoke test of %s: got status %d", url, code)
+	}
+	return nil
+}
+
+// diagnostics returns recent events and logs of the pods of app.
+// Failures to read them are included in place of their output.
+func diagnostics(
+	ctx context.Context, kubectl command.Machine, app string,
+) string {
+	var buf strings.Builder
+	buf.WriteString(podEvents(ctx, kubectl, app))
+	logs, err := command.Read(ctx, kubectl,
+		"logs", "-l", "app="+app, "--all-containers", "--prefix",
+		"--tail=50")
+	if err != nil {
+		logs = fmt.Sprintf("could not get logs: %v", err)
+	}
+	buf.WriteString("--- logs:\n" + logs + "\n")
+	return buf.String()
+}
+
+// podEvents returns recent events of the pods of app,
+// matched by their whole names so that pods of app-worker are left out.
+func podEvents(
+	ctx context.Context, kubectl command.Machine, app string,
+) string {
+	var buf strings.Builder
+	buf.WriteString("--- events:\n")
+	pods, err := command.Read(ctx, kubectl,
+		"get", "pods", "-l", "app="+app, "-o", "name")
+	if err != nil {
+		fmt.Fprintf(&buf, "could not get pods: %v\n", err)
+		return buf.String()
+	}
+	names := strings.Fields(pods)
+	events, err := command.Read(ctx, kubectl,
+		"get", "events", "--sort-by=.lastTimestamp",
+		"--field-selector=involvedObject.kind=Pod")
+	if err != nil {
+		fmt.Fprintf(&buf, "could not get events: %v\n", err)
+	}
+	for line := range strings.Lines(events) {
+		fields := strings.Fields(line)
+		if slices.ContainsFunc(fields, func(f string) bool {
+			return slices.Contains(names, f)
+		}) {
+			buf.WriteString(strings.TrimSuffix(line, "\n") + "\n")
+		}
+	}
+	return buf.String()
+}
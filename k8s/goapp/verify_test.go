@@ -0,0 +1,120 @@
+package goapp
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"labs.lesiw.io/ops/goapp"
+	"lesiw.io/command"
+	"lesiw.io/command/mock"
+	"lesiw.io/command/sub"
+)
+
+func setupCluster(t *testing.T) *mock.Machine {
+	t.Helper()
+	m := new(mock.Machine)
+	swap(t, &getKubectl, func(cluster) (command.Machine, error) {
+		return sub.Machine(m, "kubectl"), nil
+	})
+	swap(t, &getHelm, func(cluster) (command.Machine, error) {
+		return m, nil
+	})
+	return m
+}
+
+func TestVerify(t *testing.T) {
+	swap(t, &goapp.Name, "app")
+	m := setupCluster(t)
+	m.Return(strings.NewReader("200"), "kubectl", "run")
+
+	err := Ops{Port: 8080, SmokeTest: &SmokeTest{Path: "/healthz"}}.
+		verify(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if calls := mock.Calls(m, "helm"); len(calls) > 0 {
+		t.Errorf("unexpected helm calls: %v", calls)
+	}
+	run := mock.Calls(m, "kubectl", "run")
+	if len(run) != 1 {
+		t.Fatalf("got %d smoke tests, want 1", len(run))
+	}
+	args := run[0].Args
+	if url := args[len(args)-1]; url != "http://app/healthz" {
+		t.Errorf("smoke test url = %q, want http://app/healthz", url)
+	}
+}
+
+func TestVerifyRollback(t *testing.T) {
+	swap(t, &goapp.Name, "app")
+	m := setupCluster(t)
+	m.Return(strings.NewReader("503"), "kubectl", "run")
+	m.Return(strings.NewReader(
+		"5s Warning BackOff pod/app-0 Back-off restarting\n"+
+			"5s Normal Pulled pod/other-0 Pulled\n"+
+			"5s Warning Failed pod/app-worker-0 Failed\n",
+	), "kubectl", "get", "events")
+	m.Return(strings.NewReader("pod/app-0\n"), "kubectl", "get", "pods")
+	m.Return(strings.NewReader("[pod/app-0/app] panic: boom"),
+		"kubectl", "logs")
+	m.Return(strings.NewReader(`[{"revision": 1}, {"revision": 2}]`),
+		"helm", "history")
+
+	err := Ops{Port: 8080}.verify(context.Background())
+	if err == nil {
+		t.Fatal("verify() should fail when the smoke test fails")
+	}
+
+	msg := err.Error()
+	for _, want := range []string{
+		"got status 503",
+		"rolled back",
+		"Back-off restarting",
+		"panic: boom",
+	} {
+		if !strings.Contains(msg, want) {
+			t.Errorf("error missing %q:\n%s", want, msg)
+		}
+	}
+	if strings.Contains(msg, "other-0") || strings.Contains(msg, "worker") {
+		t.Errorf("error includes other pods' events:\n%s", msg)
+	}
+	rollback := mock.Calls(m, "helm", "rollback")
+	if len(rollback) != 1 {
+		t.Errorf("got %d rollbacks, want 1", len(rollback))
+	}
+}
+
+func TestVerifyFirstInstall(t *testing.T) {
+	swap(t, &goapp.Name, "app")
+	m := setupCluster(t)
+	m.Return(strings.NewReader("503"), "kubectl", "run")
+	m.Return(strings.NewReader(`[{"revision": 1}]`), "helm", "history")
+
+	err := Ops{Port: 8080}.verify(context.Background())
+	if err == nil {
+		t.Fatal("verify() should fail when the smoke test fails")
+	}
+
+	if msg := err.Error(); !strings.Contains(msg, "got status 503") ||
+		!strings.Contains(msg, "no previous revision") {
+		t.Errorf("error = %v, want the smoke test failure", err)
+	}
+	if calls := mock.Calls(m, "helm", "rollback"); len(calls) > 0 {
+		t.Errorf("rolled back a first install: %v", calls)
+	}
+}
+
+func TestVerifyStatus(t *testing.T) {
+	swap(t, &goapp.Name, "app")
+	m := setupCluster(t)
+	m.Return(strings.NewReader("404"), "kubectl", "run")
+
+	err := Ops{Port: 8080, SmokeTest: &SmokeTest{Status: 200}}.
+		verify(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "want 200") {
+		t.Errorf("verify() = %v, want status mismatch", err)
+	}
+}